@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 )
 
 // ToolHandler processes a single tool call and returns a result string.
@@ -30,7 +31,8 @@ func InitSession(systemPrompt, userPrompt string) Session {
 
 // ExecuteToolCalls runs all tool handlers concurrently (guide section 4) and
 // returns a ToolResultMessage for each call.  Handler errors are captured as
-// result strings so the agent loop can continue uninterrupted.
+// result strings so the agent loop can continue uninterrupted.  Each handler's
+// context carries its call's ID (see ToolCallIDFromContext).
 func ExecuteToolCalls(ctx context.Context, calls []ToolCallMessage, handlers map[string]ToolHandler) []Message {
 	results := make([]Message, len(calls))
 	var wg sync.WaitGroup
@@ -43,7 +45,7 @@ func ExecuteToolCalls(ctx context.Context, calls []ToolCallMessage, handlers map
 			if !ok {
 				output = fmt.Sprintf("Error: unknown tool %q", call.Name)
 			} else {
-				out, err := handler(ctx, call.Input)
+				out, err := handler(context.WithValue(ctx, toolCallIDKey{}, call.ID), call.Input)
 				if err != nil {
 					output = "Error: " + err.Error()
 				} else {
@@ -76,6 +78,26 @@ type agentLoopConfig struct {
 	logFunc       LogFunc
 	compactFunc   CompactFunc
 	usageFunc     UsageFunc
+	validate      bool
+	repair        bool
+	toolChoice    ToolChoiceFunc
+	subBudget     *subBudget
+	answer        *answerSpec // set by AgentLoopAnswer and AgentLoopAnswerSchema
+	halt          func() bool // checked after each batch of tool results; set by Team.Run
+	notebook      bool
+	reflection    *reflection
+	stop          []StopCondition
+	onRun         func(id string)
+}
+
+// stopped reports whether any stop condition is met.
+func (c *agentLoopConfig) stopped(s Session, it Iteration) bool {
+	for _, cond := range c.stop {
+		if cond(s, it) {
+			return true
+		}
+	}
+	return false
 }
 
 // WithMaxIterations sets the maximum number of model invocations before the
@@ -103,6 +125,27 @@ func WithUsageChecker(fn UsageFunc) AgentLoopOption {
 	return func(c *agentLoopConfig) { c.usageFunc = fn }
 }
 
+// WithValidation makes the loop call Session.Validate before each model
+// invocation and return the *ValidationError instead of sending a session
+// the API would reject.
+func WithValidation() AgentLoopOption {
+	return func(c *agentLoopConfig) { c.validate = true }
+}
+
+// WithRepair makes the loop pass the session through Repair before each
+// model invocation, so histories left broken by cancelled runs or manual
+// edits are fixed up rather than rejected.
+func WithRepair() AgentLoopOption {
+	return func(c *agentLoopConfig) { c.repair = true }
+}
+
+// WithToolChoicePolicy sets a function that picks the tool choice for each
+// iteration, e.g. ForceToolFirst("plan").  The choice reaches the model
+// through the context (see ContextWithToolChoice).
+func WithToolChoicePolicy(fn ToolChoiceFunc) AgentLoopOption {
+	return func(c *agentLoopConfig) { c.toolChoice = fn }
+}
+
 // defaultCompactor returns a CompactFunc that truncates ThinkingMessage,
 // ToolCallMessage, and ToolResultMessage content once at least two assistant
 // responses have appeared after them in the session.  A per-call index set
@@ -149,12 +192,16 @@ func defaultCompactor() CompactFunc {
 }
 
 // AgentLoop drives the model in a loop until it produces a response with no
-// tool calls (guide section 5).  The updated session is returned.
+// tool calls (guide section 5), or a condition set with WithStopConditions
+// is met.  The updated session is returned.
 //
 // invokeModel is the model invocation function (e.g. InvokeClaude()).
 // tools provides both the definitions passed to invokeModel and the handler
 // functions used to execute them.
-func AgentLoop(ctx context.Context, invokeModel InvokeModelFunc, tools []Tool, session Session, opts ...AgentLoopOption) (Session, error) {
+//
+// Each call is a Run linked to any run and tool call in ctx, and is recorded
+// in the context's RunRegistry if there is one.
+func AgentLoop(ctx context.Context, invokeModel InvokeModelFunc, tools []Tool, session Session, opts ...AgentLoopOption) (_ Session, err error) {
 	cfg := &agentLoopConfig{maxIterations: 30, compactFunc: defaultCompactor()}
 	for _, o := range opts {
 		o(cfg)
@@ -167,25 +214,111 @@ func AgentLoop(ctx context.Context, invokeModel InvokeModelFunc, tools []Tool, s
 		defs[i] = t.Definition
 		handlers[t.Definition.Name] = t.Handler
 	}
+	if cfg.answer != nil {
+		defs = append(defs, cfg.answer.def)
+		handlers[SubmitAnswerTool] = cfg.answer.handle
+	}
+	var nb *notebook
+	if cfg.notebook {
+		n, err := NotebookFromSession(session)
+		if err != nil {
+			return session, err
+		}
+		nb = &notebook{n: n}
+		for _, t := range nb.tools() {
+			defs = append(defs, t.Definition)
+			handlers[t.Definition.Name] = t.Handler
+		}
+	}
+	maxErr := fmt.Errorf("agent loop reached maximum iterations (%d)", cfg.maxIterations)
+
+	if sb := cfg.subBudget; sb != nil {
+		var b *Budget
+		if parent, ok := BudgetFromContext(ctx); ok {
+			b = parent.Child(sb.name, sb.limits)
+		} else {
+			b = NewBudget(sb.name, sb.limits)
+		}
+		ctx = ContextWithBudget(ctx, b)
+	}
+	budget, _ := BudgetFromContext(ctx)
 
 	var totalUsage Usage
+	critiques := 0
+	wrapUp := false // a stop condition was met in a structured-answer loop
+	// revise shows the final answer to the critic, if there is one, and
+	// reports whether it was rejected and the loop should continue with
+	// the critic's feedback.
+	revise := func(i int) (bool, error) {
+		if cfg.reflection == nil || i == cfg.maxIterations-1 {
+			return false, nil
+		}
+		feedback, retry, err := cfg.reflection.review(ctx, session, critiques)
+		if err != nil || !retry {
+			return false, err
+		}
+		critiques++
+		if cfg.answer != nil {
+			cfg.answer.reject()
+			feedback.Content += "\n\n" + answerReminder
+		}
+		session.Add(feedback)
+		if cfg.logFunc != nil {
+			cfg.logFunc(feedback)
+		}
+		return true, nil
+	}
+	started := time.Now()
+	ctx, finish := startRun(ctx)
+	defer func() { finish(session, totalUsage, err) }()
+	if cfg.onRun != nil {
+		id, _ := RunIDFromContext(ctx)
+		cfg.onRun(id)
+	}
+
 	for i := range cfg.maxIterations {
 		if cfg.usageFunc != nil && cfg.usageFunc(totalUsage) {
 			break
 		}
 
+		if budget != nil {
+			if err := budget.Check(); err != nil {
+				return session, err
+			}
+		}
+
+		if cfg.repair {
+			session = Repair(session)
+		}
+
 		if cfg.compactFunc != nil {
 			session = cfg.compactFunc(session)
 		}
 
-		newMsgs, usage, err := invokeModel(ctx, defs, session)
+		if cfg.validate {
+			if err := session.Validate(); err != nil {
+				return session, err
+			}
+		}
+
+		invokeCtx := ctx
+		if wrapUp {
+			invokeCtx = ContextWithToolChoice(ctx, ToolChoiceTool(SubmitAnswerTool))
+		} else if cfg.toolChoice != nil {
+			if tc := cfg.toolChoice(i, session); !tc.IsZero() {
+				invokeCtx = ContextWithToolChoice(ctx, tc)
+			}
+		}
+
+		newMsgs, usage, err := invokeModel(invokeCtx, defs, session)
+		if budget != nil {
+			budget.Debit(usage)
+		}
 		if err != nil {
 			return session, err
 		}
-		totalUsage.InputTokens += usage.InputTokens
-		totalUsage.OutputTokens += usage.OutputTokens
-		totalUsage.CacheCreationInputTokens += usage.CacheCreationInputTokens
-		totalUsage.CacheReadInputTokens += usage.CacheReadInputTokens
+		totalUsage = totalUsage.Add(usage)
+		it := Iteration{Index: i, Start: len(session.Messages), Started: started}
 		session.Add(newMsgs...)
 		if cfg.logFunc != nil {
 			for _, m := range newMsgs {
@@ -201,22 +334,80 @@ func AgentLoop(ctx context.Context, invokeModel InvokeModelFunc, tools []Tool, s
 			}
 		}
 
-		// No tool calls means the model is done.
+		// No tool calls means the model is done, unless it still owes a
+		// structured answer or a critic rejects its answer.
 		if len(toolCalls) == 0 {
-			break
+			if cfg.answer == nil {
+				retry, err := revise(i)
+				if err != nil {
+					return session, err
+				}
+				if !retry {
+					break
+				}
+				continue
+			}
+			if i == cfg.maxIterations-1 {
+				return session, maxErr
+			}
+			reminder := UserMessage{answerReminder}
+			session.Add(reminder)
+			if cfg.logFunc != nil {
+				cfg.logFunc(reminder)
+			}
+			continue
 		}
 
-		if i == cfg.maxIterations-1 {
-			return session, fmt.Errorf("agent loop reached maximum iterations (%d)", cfg.maxIterations)
+		// A final-iteration submission still gets its result below.
+		if i == cfg.maxIterations-1 && cfg.answer == nil {
+			return session, maxErr
 		}
 
 		results := ExecuteToolCalls(ctx, toolCalls, handlers)
 		session.Add(results...)
+		if nb != nil {
+			if err := session.SetState(NotebookStateKey, nb.snapshot()); err != nil {
+				return session, err
+			}
+		}
 		if cfg.logFunc != nil {
 			for _, m := range results {
 				cfg.logFunc(m)
 			}
 		}
+
+		if cfg.halt != nil && cfg.halt() {
+			break
+		}
+
+		if cfg.answer == nil {
+			if cfg.stopped(session, it) {
+				break
+			}
+			continue
+		}
+		if cfg.answer.accepted() != nil {
+			retry, err := revise(i)
+			if err != nil {
+				return session, err
+			}
+			if !retry {
+				break
+			}
+			continue
+		}
+		if i == cfg.maxIterations-1 {
+			return session, maxErr
+		}
+		// Stopping now would end without an answer, so ask for it instead.
+		if !wrapUp && cfg.stopped(session, it) {
+			wrapUp = true
+			reminder := UserMessage{answerReminder}
+			session.Add(reminder)
+			if cfg.logFunc != nil {
+				cfg.logFunc(reminder)
+			}
+		}
 	}
 
 	return session, nil
@@ -332,13 +332,8 @@ func TestAgentLoopAddition(t *testing.T) {
 	}
 
 	// Confirm the final assistant message mentions the correct answer.
-	var finalReply string
-	for _, msg := range session.Messages {
-		if am, ok := msg.(AssistantMessage); ok {
-			finalReply = am.Content
-		}
-	}
-	if finalReply == "" {
+	finalReply, ok := session.FinalAnswer()
+	if !ok || finalReply == "" {
 		t.Fatal("no assistant reply in session")
 	}
 	if !strings.Contains(finalReply, "6912") && !strings.Contains(finalReply, "6,912") {
@@ -347,67 +342,56 @@ func TestAgentLoopAddition(t *testing.T) {
 }
 
 // TestAgentLoopSubagent demonstrates a subagent pattern: the assess_fact tool
-// wraps its own AgentLoop call so the parent agent can delegate fact-grading to
+// is an AgentTool so the parent agent can delegate fact-grading to
 // a specialised inner agent.
 //
 // Parent: generates science facts and calls assess_fact for each one.
 // Subagent: receives a single fact, grades it on a five-point scale, and
 // explains its reasoning.
 func TestAgentLoopSubagent(t *testing.T) {
+	type factAssessment struct {
+		Grade       string `json:"grade" enum:"not interesting,mildly interesting,interesting,very interesting,mind-bendingly interesting"`
+		Explanation string `json:"explanation"`
+	}
+
+	// topGrade matches an assess_fact result graded exactly
+	// "mind-bendingly interesting"; a substring match would also accept
+	// "not mind-bendingly interesting" in the explanation.
+	topGrade := func(r ToolResultMessage) bool {
+		var a factAssessment
+		return json.Unmarshal([]byte(r.Output), &a) == nil && a.Grade == "mind-bendingly interesting"
+	}
+
 	skipIfNoKey(t)
 
 	invokeModel := InvokeClaude()
 
-	assessFactTool := Tool{
-		Definition: ToolDefinition{
-			Name:        "assess_fact",
-			Description: "Assess how interesting a given fact is. Returns a grade and an explanation.",
-			InputSchema: ToolInputSchema{
-				Type: "object",
-				Properties: map[string]any{
-					"fact": map[string]any{
-						"type":        "string",
-						"description": "The fact to assess for interestingness.",
-					},
+	assessFactTool := AgentTool("assess_fact",
+		"Assess how interesting a given fact is. Returns a grade and an explanation.",
+		`You are a critical expert at assessing how interesting facts are.
+Before assigning a grade, briefly critique the fact: identify what makes it dull, obvious, or overly familiar to most people.
+Then, weighing that critique, submit a grade with a short explanation that incorporates your critique and justifies the rating.`,
+		invokeModel, nil,
+		WithInput(ToolInputSchema{
+			Type: "object",
+			Properties: map[string]any{
+				"fact": map[string]any{
+					"type":        "string",
+					"description": "The fact to assess for interestingness.",
 				},
-				Required: []string{"fact"},
 			},
-		},
-		Handler: func(ctx context.Context, input json.RawMessage) (string, error) {
+			Required: []string{"fact"},
+		}, func(input json.RawMessage) (string, error) {
 			var args struct {
 				Fact string `json:"fact"`
 			}
 			if err := json.Unmarshal(input, &args); err != nil {
 				return "", err
 			}
-
-			subSession := InitSession(
-				`You are a critical expert at assessing how interesting facts are.
-Before assigning a grade, briefly critique the fact: identify what makes it dull, obvious, or overly familiar to most people.
-Then, weighing that critique, grade the fact using exactly one of these labels on the first line:
-  not interesting | mildly interesting | interesting | very interesting | mind-bendingly interesting
-Follow the grade with a short explanation that incorporates your critique and justifies the rating.`,
-				fmt.Sprintf("Please assess this fact: %s", args.Fact),
-			)
-
-			subSession, err := AgentLoop(context.Background(), invokeModel, nil, subSession)
-			if err != nil {
-				return "", err
-			}
-
-			// Return the last assistant message produced by the subagent.
-			var assessment string
-			for _, msg := range subSession.Messages {
-				if am, ok := msg.(AssistantMessage); ok {
-					assessment = am.Content
-				}
-			}
-			if assessment == "" {
-				return "No assessment produced.", nil
-			}
-			return assessment, nil
-		},
-	}
+			return fmt.Sprintf("Please assess this fact: %s", args.Fact), nil
+		}),
+		WithStructuredResult(SchemaFor[factAssessment]()),
+	)
 
 	session := InitSession(
 		"You are a knowledgeable assistant that generates interesting science facts. "+
@@ -425,34 +409,27 @@ Follow the grade with a short explanation that incorporates your critique and ju
 	session, err := AgentLoop(context.Background(), invokeModel, []Tool{assessFactTool}, session,
 		WithMaxIterations(5),
 		WithLogger(logMsg),
+		// Stop as soon as the goal is reached rather than relying on the
+		// model to notice.
+		WithStopConditions(StopOnToolResult(topGrade)),
 	)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Confirm the parent agent actually invoked the subagent tool.
-	var toolCallCount int
-	for _, msg := range session.Messages {
-		if tc, ok := msg.(ToolCallMessage); ok && tc.Name == "assess_fact" {
-			toolCallCount++
-		}
-	}
+	toolCallCount := session.ToolStats()["assess_fact"].Calls
 	if toolCallCount == 0 {
 		t.Fatal("expected at least one call to assess_fact, got none")
 	}
 	t.Logf("assess_fact called %d time(s)", toolCallCount)
 
 	// Confirm that at least one tool result achieved the top grade.
-	var topGradeAchieved bool
-	for _, msg := range session.Messages {
-		if tr, ok := msg.(ToolResultMessage); ok {
-			if strings.Contains(strings.ToLower(tr.Output), "mind-bendingly interesting") {
-				topGradeAchieved = true
-				break
-			}
-		}
-	}
-	if !topGradeAchieved {
+	topGrades := NewQuery(session).Where(func(m Message) bool {
+		r, ok := m.(ToolResultMessage)
+		return ok && topGrade(r)
+	})
+	if topGrades.Count() == 0 {
 		t.Error("no assess_fact result achieved the \"mind-bendingly interesting\" grade")
 	}
 }
@@ -0,0 +1,211 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxAgentDepth is the nesting limit of AgentTool subagents unless
+// overridden with WithMaxDepth.
+const DefaultMaxAgentDepth = 3
+
+// ErrMaxDepth is returned (wrapped) by an AgentTool handler started at or
+// beyond its depth limit.
+var ErrMaxDepth = errors.New("maximum agent nesting depth reached")
+
+type agentDepthKey struct{}
+
+// AgentDepth returns how many AgentTool subagents enclose ctx; 0 at the top
+// level.
+func AgentDepth(ctx context.Context) int {
+	d, _ := ctx.Value(agentDepthKey{}).(int)
+	return d
+}
+
+// ResultFunc extracts a tool result from a subagent's finished session.
+type ResultFunc func(Session) (string, error)
+
+// ResultLastText returns the subagent's final text answer.  It is the
+// default ResultFunc.
+func ResultLastText(s Session) (string, error) {
+	answer, ok := s.FinalAnswer()
+	if !ok || answer == "" {
+		return "", errors.New("subagent produced no answer")
+	}
+	return answer, nil
+}
+
+// ResultTranscript returns a one-line-per-message summary of everything
+// the subagent did after its prompt, ending with its final answer, for
+// parents that need to see the working as well as the conclusion.
+func ResultTranscript(s Session) (string, error) {
+	final := -1
+	for i, m := range s.Messages {
+		if _, ok := m.(AssistantMessage); ok {
+			final = i
+		}
+	}
+	var lines []string
+	for i, m := range s.Messages {
+		switch m.(type) {
+		case SystemMessage, UserMessage:
+			continue
+		}
+		if i != final {
+			lines = append(lines, summarizeMessage(m))
+		}
+	}
+	if final >= 0 {
+		lines = append(lines, "Final answer: "+s.Messages[final].(AssistantMessage).Content)
+	}
+	if len(lines) == 0 {
+		return "", errors.New("subagent produced no output")
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// PromptFunc maps a tool call's input to the subagent's first user message.
+type PromptFunc func(input json.RawMessage) (string, error)
+
+// TranscriptFunc receives a subagent's session when its run ends, with the
+// tool input that started it and the run's error, if any.
+type TranscriptFunc func(ctx context.Context, input json.RawMessage, sub Session, err error)
+
+// AgentToolOption configures AgentTool.
+type AgentToolOption func(*agentToolConfig)
+
+type agentToolConfig struct {
+	schema       ToolInputSchema
+	prompt       PromptFunc
+	result       ResultFunc
+	answerSchema *ToolInputSchema
+	maxDepth     int
+	loopOpts     []AgentLoopOption
+	onTranscript TranscriptFunc
+}
+
+// WithInput replaces the default {"task": string} input with schema, using
+// prompt to turn each call's input into the subagent's first message.
+func WithInput(schema ToolInputSchema, prompt PromptFunc) AgentToolOption {
+	return func(c *agentToolConfig) { c.schema, c.prompt = schema, prompt }
+}
+
+// WithResult sets how the tool result is extracted from the subagent's
+// session (default ResultLastText).
+func WithResult(fn ResultFunc) AgentToolOption {
+	return func(c *agentToolConfig) { c.result = fn }
+}
+
+// WithStructuredResult makes the subagent submit its answer through a
+// submit_answer tool matching schema (see AgentLoopAnswerSchema).  The tool
+// result is the submitted JSON.
+func WithStructuredResult(schema ToolInputSchema) AgentToolOption {
+	return func(c *agentToolConfig) { c.answerSchema = &schema }
+}
+
+// WithMaxDepth sets how deeply AgentTool subagents may nest before the
+// tool refuses to run (default DefaultMaxAgentDepth).  Depth counts every
+// enclosing AgentTool, not just this one, so mutually recursive agents are
+// bounded too.
+func WithMaxDepth(n int) AgentToolOption {
+	return func(c *agentToolConfig) { c.maxDepth = n }
+}
+
+// WithLoopOptions sets options passed to the subagent's AgentLoop.
+func WithLoopOptions(opts ...AgentLoopOption) AgentToolOption {
+	return func(c *agentToolConfig) { c.loopOpts = opts }
+}
+
+// WithTranscriptHandler sets a function that receives every finished
+// subagent session, for logging.
+func WithTranscriptHandler(fn TranscriptFunc) AgentToolOption {
+	return func(c *agentToolConfig) { c.onTranscript = fn }
+}
+
+// taskPrompt is the default PromptFunc for the {"task": string} input.
+func taskPrompt(input json.RawMessage) (string, error) {
+	var args struct {
+		Task string `json:"task"`
+	}
+	if err := json.Unmarshal(input, &args); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(args.Task) == "" {
+		return "", errors.New("task is required")
+	}
+	return args.Task, nil
+}
+
+// AgentTool wraps an agent as a Tool, so a parent agent can delegate work
+// to it.  Each call runs a fresh AgentLoop with systemPrompt, model and
+// tools, seeded with a prompt built from the call's input, and returns the
+// extracted result.  The subagent runs with the handler's context, so it
+// shares any budget in it; when there is one, its usage is recorded under
+// a sub-budget named after the tool.
+func AgentTool(name, description, systemPrompt string, model InvokeModelFunc, tools []Tool, opts ...AgentToolOption) Tool {
+	cfg := &agentToolConfig{
+		schema: ToolInputSchema{
+			Type: "object",
+			Properties: map[string]any{
+				"task": map[string]any{
+					"type":        "string",
+					"description": "A complete, self-contained description of the task to delegate.",
+				},
+			},
+			Required: []string{"task"},
+		},
+		prompt:   taskPrompt,
+		result:   ResultLastText,
+		maxDepth: DefaultMaxAgentDepth,
+	}
+	for _, o := range opts {
+		o(cfg)
+	}
+
+	handler := func(ctx context.Context, input json.RawMessage) (string, error) {
+		depth := AgentDepth(ctx)
+		if depth >= cfg.maxDepth {
+			return "", fmt.Errorf("%s: %w (%d)", name, ErrMaxDepth, cfg.maxDepth)
+		}
+		ctx = context.WithValue(ctx, agentDepthKey{}, depth+1)
+
+		prompt, err := cfg.prompt(input)
+		if err != nil {
+			return "", fmt.Errorf("invalid input: %w", err)
+		}
+
+		var loopOpts []AgentLoopOption
+		if _, ok := BudgetFromContext(ctx); ok {
+			loopOpts = append(loopOpts, WithSubBudget(name, BudgetLimits{}))
+		}
+		loopOpts = append(loopOpts, cfg.loopOpts...)
+
+		session := InitSession(systemPrompt, prompt)
+		var out string
+		if cfg.answerSchema != nil {
+			var raw json.RawMessage
+			raw, session, err = AgentLoopAnswerSchema(ctx, model, tools, session, *cfg.answerSchema, loopOpts...)
+			out = string(raw)
+		} else {
+			session, err = AgentLoop(ctx, model, tools, session, loopOpts...)
+		}
+		if cfg.onTranscript != nil {
+			cfg.onTranscript(ctx, input, session, err)
+		}
+		if err != nil {
+			return "", err
+		}
+		if cfg.answerSchema != nil {
+			return out, nil
+		}
+		return cfg.result(session)
+	}
+
+	return Tool{
+		Definition: ToolDefinition{Name: name, Description: description, InputSchema: cfg.schema},
+		Handler:    handler,
+	}
+}
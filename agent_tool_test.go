@@ -0,0 +1,113 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAgentTool(t *testing.T) {
+	var prompts []string
+	model := func(_ context.Context, _ []ToolDefinition, s Session) ([]Message, Usage, error) {
+		if _, ok := s.Messages[len(s.Messages)-1].(UserMessage); ok {
+			prompts = append(prompts, s.Messages[len(s.Messages)-1].(UserMessage).Content)
+			return []Message{ToolCallMessage{ID: "n1", Name: "noop", Input: json.RawMessage(`{}`)}}, Usage{InputTokens: 10}, nil
+		}
+		return []Message{AssistantMessage{"Paris."}}, Usage{InputTokens: 10}, nil
+	}
+
+	var transcripts []Session
+	tool := AgentTool("research", "Researches a question.", "You research.", model, []Tool{noopTool},
+		WithTranscriptHandler(func(_ context.Context, _ json.RawMessage, sub Session, _ error) {
+			transcripts = append(transcripts, sub)
+		}))
+	if tool.Definition.InputSchema.Required[0] != "task" {
+		t.Errorf("schema: %+v", tool.Definition.InputSchema)
+	}
+
+	out, err := tool.Handler(context.Background(), json.RawMessage(`{"task":"Capital of France?"}`))
+	if err != nil || out != "Paris." {
+		t.Fatalf("got %q, %v", out, err)
+	}
+	if len(prompts) != 1 || prompts[0] != "Capital of France?" {
+		t.Errorf("prompts: %q", prompts)
+	}
+	if len(transcripts) != 1 || len(transcripts[0].Messages) != 5 {
+		t.Errorf("transcripts: %+v", transcripts)
+	}
+
+	if _, err := tool.Handler(context.Background(), json.RawMessage(`{"task":" "}`)); err == nil || !strings.Contains(err.Error(), "task is required") {
+		t.Errorf("empty task: got %v", err)
+	}
+
+	// A custom input and the transcript result.
+	tool = AgentTool("lookup", "", "sys", model, []Tool{noopTool},
+		WithInput(ToolInputSchema{Type: "object"}, func(input json.RawMessage) (string, error) {
+			var args struct{ City string }
+			err := json.Unmarshal(input, &args)
+			return "Which country is " + args.City + " in?", err
+		}),
+		WithResult(ResultTranscript))
+	prompts = nil
+	out, err = tool.Handler(context.Background(), json.RawMessage(`{"city":"Lyon"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompts[0] != "Which country is Lyon in?" {
+		t.Errorf("prompt: %q", prompts[0])
+	}
+	if want := "tool_call: noop {}\ntool_result: ok\nFinal answer: Paris."; out != want {
+		t.Errorf("transcript:\n%s\nwant:\n%s", out, want)
+	}
+
+	// Usage is attributed to a sub-budget named after the tool.
+	root := NewBudget("run", BudgetLimits{})
+	if _, err := tool.Handler(ContextWithBudget(context.Background(), root), json.RawMessage(`{"city":"Nice"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if r := root.Report(); len(r.Children) != 1 || r.Children[0].Name != "lookup" || r.Children[0].Usage.InputTokens != 20 {
+		t.Errorf("budget:\n%s", r)
+	}
+}
+
+func TestAgentToolStructured(t *testing.T) {
+	model, _ := scriptedModel([]Message{submit("a1", `{"grade":"high","score":9}`)})
+	tool := AgentTool("grader", "", "sys", model, nil, WithStructuredResult(SchemaFor[gradeAnswer]()))
+	out, err := tool.Handler(context.Background(), json.RawMessage(`{"task":"Grade this."}`))
+	if err != nil || out != `{"grade":"high","score":9}` {
+		t.Errorf("got %s, %v", out, err)
+	}
+}
+
+// TestAgentToolDepth gives an agent itself as a tool and checks that the
+// recursion stops at the depth limit.
+func TestAgentToolDepth(t *testing.T) {
+	var errs []error
+	var self Tool
+	recurse := Tool{
+		Definition: ToolDefinition{Name: "self", InputSchema: ToolInputSchema{Type: "object"}},
+		Handler: func(ctx context.Context, input json.RawMessage) (string, error) {
+			out, err := self.Handler(ctx, input)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			return out, err
+		},
+	}
+	model := func(_ context.Context, _ []ToolDefinition, s Session) ([]Message, Usage, error) {
+		if _, ok := s.Messages[len(s.Messages)-1].(ToolResultMessage); ok {
+			return []Message{AssistantMessage{"done"}}, Usage{}, nil
+		}
+		return []Message{ToolCallMessage{ID: "s", Name: "self", Input: json.RawMessage(`{"task":"again"}`)}}, Usage{}, nil
+	}
+	self = AgentTool("self", "", "sys", model, []Tool{recurse}, WithMaxDepth(2))
+
+	if _, err := self.Handler(context.Background(), json.RawMessage(`{"task":"start"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], ErrMaxDepth) {
+		t.Errorf("got %v, want one ErrMaxDepth", errs)
+	}
+}
@@ -0,0 +1,95 @@
+package agentlooptest
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	agentloop "github.com/tdb-alcorn/agent-loop-go"
+)
+
+// Matcher checks an incoming call, returning a descriptive error if it does
+// not match.
+type Matcher func(Call) error
+
+// MatchFunc adapts a predicate over the call into a Matcher.
+func MatchFunc(desc string, pred func(Call) bool) Matcher {
+	return func(c Call) error {
+		if !pred(c) {
+			return fmt.Errorf("call does not match: %s", desc)
+		}
+		return nil
+	}
+}
+
+// HasTools requires every named tool to be offered in the call.
+func HasTools(names ...string) Matcher {
+	return func(c Call) error {
+		offered := c.ToolNames()
+		for _, n := range names {
+			if !slices.Contains(offered, n) {
+				return fmt.Errorf("tool %q not offered (have %v)", n, offered)
+			}
+		}
+		return nil
+	}
+}
+
+// MessageCount requires the session to contain exactly n messages.
+func MessageCount(n int) Matcher {
+	return func(c Call) error {
+		if got := len(c.Session.Messages); got != n {
+			return fmt.Errorf("session has %d messages, want %d", got, n)
+		}
+		return nil
+	}
+}
+
+// LastUserContains requires the most recent UserMessage to contain substr.
+func LastUserContains(substr string) Matcher {
+	return func(c Call) error {
+		msgs := agentloop.MessagesOf[agentloop.UserMessage](c.Session)
+		if len(msgs) == 0 {
+			return fmt.Errorf("no user message in session")
+		}
+		if last := msgs[len(msgs)-1].Content; !strings.Contains(last, substr) {
+			return fmt.Errorf("last user message %q does not contain %q", last, substr)
+		}
+		return nil
+	}
+}
+
+// LastToolResult requires the session to end with a result for call id whose
+// output contains substr.  The result need not be the final message if
+// several results were returned together.
+func LastToolResult(id, substr string) Matcher {
+	return func(c Call) error {
+		msgs := c.Session.Messages
+		for i := len(msgs) - 1; i >= 0; i-- {
+			r, ok := msgs[i].(agentloop.ToolResultMessage)
+			if !ok {
+				break
+			}
+			if r.ID != id {
+				continue
+			}
+			if !strings.Contains(r.Output, substr) {
+				return fmt.Errorf("result for %q is %q, want it to contain %q", id, r.Output, substr)
+			}
+			return nil
+		}
+		return fmt.Errorf("session does not end with a result for %q", id)
+	}
+}
+
+// SystemContains requires some SystemMessage to contain substr.
+func SystemContains(substr string) Matcher {
+	return func(c Call) error {
+		for _, s := range agentloop.MessagesOf[agentloop.SystemMessage](c.Session) {
+			if strings.Contains(s.Content, substr) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no system message contains %q", substr)
+	}
+}
@@ -0,0 +1,189 @@
+// Package agentlooptest provides a scripted fake model for testing agents,
+// tools and loop options without network access.
+//
+// A Model replays a queue of scripted responses through its Invoke method,
+// which satisfies agentloop.InvokeModelFunc:
+//
+//	m := agentlooptest.NewModel()
+//	m.Reply(agentlooptest.ToolCall("c1", "add", map[string]int{"a": 1, "b": 2})).
+//		WithUsage(agentloop.Usage{InputTokens: 100, OutputTokens: 20})
+//	m.Reply(agentlooptest.Text("3")).Expect(agentlooptest.LastToolResult("c1", "3"))
+//
+//	session, err := agentloop.AgentLoop(ctx, m.Invoke, tools, session)
+//	m.AssertExhausted(t)
+package agentlooptest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	agentloop "github.com/tdb-alcorn/agent-loop-go"
+)
+
+// ErrExhausted is returned by Invoke once every scripted step has been used.
+var ErrExhausted = errors.New("agentlooptest: no scripted responses remain")
+
+// Call records the arguments of a single Invoke.
+type Call struct {
+	Tools      []agentloop.ToolDefinition
+	Session    agentloop.Session
+	ToolChoice agentloop.ToolChoice // from agentloop.ToolChoiceFromContext; zero if unset
+}
+
+// ToolNames returns the names of the tools offered in the call.
+func (c Call) ToolNames() []string {
+	names := make([]string, len(c.Tools))
+	for i, t := range c.Tools {
+		names[i] = t.Name
+	}
+	return names
+}
+
+// Step is one scripted model response.  Steps are created by Model.Reply and
+// Model.Fail and configured with their chaining methods before the model is
+// used.
+type Step struct {
+	msgs     []agentloop.Message
+	usage    agentloop.Usage
+	err      error
+	delay    time.Duration
+	matchers []Matcher
+}
+
+// WithUsage sets the token usage reported for this step.
+func (s *Step) WithUsage(u agentloop.Usage) *Step {
+	s.usage = u
+	return s
+}
+
+// After delays the response by d, returning ctx.Err() if the context is
+// cancelled first.
+func (s *Step) After(d time.Duration) *Step {
+	s.delay = d
+	return s
+}
+
+// Expect adds matchers that the incoming call must satisfy.  If any matcher
+// fails, Invoke returns its error instead of the scripted response.
+func (s *Step) Expect(matchers ...Matcher) *Step {
+	s.matchers = append(s.matchers, matchers...)
+	return s
+}
+
+// Model is a scripted fake model.  It is safe for concurrent use; steps are
+// consumed in the order they were queued regardless of caller.
+type Model struct {
+	mu    sync.Mutex
+	steps []*Step
+	next  int
+	calls []Call
+}
+
+// NewModel returns a Model with an empty script.
+func NewModel() *Model {
+	return &Model{}
+}
+
+// Reply queues a step that responds with msgs.
+func (m *Model) Reply(msgs ...agentloop.Message) *Step {
+	return m.enqueue(&Step{msgs: msgs})
+}
+
+// Fail queues a step that returns err, as a failed API call would.
+func (m *Model) Fail(err error) *Step {
+	return m.enqueue(&Step{err: err})
+}
+
+func (m *Model) enqueue(s *Step) *Step {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.steps = append(m.steps, s)
+	return s
+}
+
+// Invoke consumes the next scripted step.  Its signature matches
+// agentloop.InvokeModelFunc, so m.Invoke can be passed to AgentLoop directly.
+func (m *Model) Invoke(ctx context.Context, tools []agentloop.ToolDefinition, session agentloop.Session) ([]agentloop.Message, agentloop.Usage, error) {
+	call := Call{
+		Tools:   append([]agentloop.ToolDefinition(nil), tools...),
+		Session: agentloop.Session{Messages: append([]agentloop.Message(nil), session.Messages...)},
+	}
+	call.ToolChoice, _ = agentloop.ToolChoiceFromContext(ctx)
+
+	m.mu.Lock()
+	m.calls = append(m.calls, call)
+	n := m.next
+	if n >= len(m.steps) {
+		m.mu.Unlock()
+		return nil, agentloop.Usage{}, ErrExhausted
+	}
+	step := m.steps[n]
+	m.next++
+	m.mu.Unlock()
+
+	for _, match := range step.matchers {
+		if err := match(call); err != nil {
+			return nil, agentloop.Usage{}, fmt.Errorf("agentlooptest: step %d: %w", n, err)
+		}
+	}
+
+	if step.delay > 0 {
+		timer := time.NewTimer(step.delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, agentloop.Usage{}, ctx.Err()
+		}
+	}
+
+	if step.err != nil {
+		return nil, step.usage, step.err
+	}
+	return append([]agentloop.Message(nil), step.msgs...), step.usage, nil
+}
+
+// Calls returns every call received so far, in order.
+func (m *Model) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.calls...)
+}
+
+// Remaining returns the number of scripted steps not yet consumed.
+func (m *Model) Remaining() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.steps) - m.next
+}
+
+// AssertExhausted fails the test if any scripted step was not consumed.
+func (m *Model) AssertExhausted(t testing.TB) {
+	t.Helper()
+	if n := m.Remaining(); n > 0 {
+		t.Errorf("agentlooptest: %d scripted response(s) not used", n)
+	}
+}
+
+// Text returns an AssistantMessage with the given content.
+func Text(content string) agentloop.Message {
+	return agentloop.AssistantMessage{Content: content}
+}
+
+// ToolCall returns a ToolCallMessage whose input is input marshalled to
+// JSON.  It panics if input cannot be marshalled.
+func ToolCall(id, name string, input any) agentloop.Message {
+	raw, ok := input.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(input); err != nil {
+			panic(fmt.Sprintf("agentlooptest: marshal input for %s: %v", name, err))
+		}
+	}
+	return agentloop.ToolCallMessage{ID: id, Name: name, Input: raw}
+}
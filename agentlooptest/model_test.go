@@ -0,0 +1,137 @@
+package agentlooptest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	agentloop "github.com/tdb-alcorn/agent-loop-go"
+)
+
+var echoTool = agentloop.Tool{
+	Definition: agentloop.ToolDefinition{
+		Name:        "echo",
+		InputSchema: agentloop.ToolInputSchema{Type: "object"},
+	},
+	Handler: func(_ context.Context, input json.RawMessage) (string, error) {
+		return string(input), nil
+	},
+}
+
+// TestModelDrivesAgentLoop scripts a two-step tool-using run and checks the
+// loop sees the scripted usage and the model sees the tool result.
+func TestModelDrivesAgentLoop(t *testing.T) {
+	m := NewModel()
+	m.Reply(Text("calling"), ToolCall("c1", "echo", map[string]string{"say": "hi"})).
+		Expect(HasTools("echo"), LastUserContains("go")).
+		WithUsage(agentloop.Usage{InputTokens: 10, OutputTokens: 5})
+	m.Reply(Text("done")).
+		Expect(LastToolResult("c1", `"say":"hi"`), MessageCount(5)).
+		WithUsage(agentloop.Usage{InputTokens: 20, OutputTokens: 7})
+
+	var total agentloop.Usage
+	session, err := agentloop.AgentLoop(context.Background(), m.Invoke, []agentloop.Tool{echoTool},
+		agentloop.InitSession("sys", "go"),
+		agentloop.WithUsageChecker(func(u agentloop.Usage) bool { total = u; return false }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AssertExhausted(t)
+
+	if answer, _ := session.FinalAnswer(); answer != "done" {
+		t.Errorf("final answer %q", answer)
+	}
+	if total.InputTokens != 10 || total.OutputTokens != 5 {
+		t.Errorf("usage before second call: %+v", total)
+	}
+	calls := m.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, want 2", len(calls))
+	}
+	if got := calls[0].ToolNames(); len(got) != 1 || got[0] != "echo" {
+		t.Errorf("tools: %v", got)
+	}
+}
+
+func TestModelMatcherFailure(t *testing.T) {
+	m := NewModel()
+	m.Reply(Text("hi")).Expect(SystemContains("pirate"))
+
+	_, err := agentloop.AgentLoop(context.Background(), m.Invoke, nil, agentloop.InitSession("sys", "u"))
+	if err == nil || !strings.Contains(err.Error(), `no system message contains "pirate"`) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestModelFailAndExhaust(t *testing.T) {
+	boom := errors.New("overloaded")
+	m := NewModel()
+	m.Fail(boom)
+
+	s := agentloop.InitSession("sys", "u")
+	if _, _, err := m.Invoke(context.Background(), nil, s); !errors.Is(err, boom) {
+		t.Errorf("got %v, want %v", err, boom)
+	}
+	if _, _, err := m.Invoke(context.Background(), nil, s); !errors.Is(err, ErrExhausted) {
+		t.Errorf("got %v, want ErrExhausted", err)
+	}
+	if len(m.Calls()) != 2 {
+		t.Errorf("got %d recorded calls, want 2", len(m.Calls()))
+	}
+}
+
+func TestModelLatency(t *testing.T) {
+	m := NewModel()
+	m.Reply(Text("slow")).After(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := m.Invoke(ctx, nil, agentloop.Session{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want DeadlineExceeded", err)
+	}
+
+	m.Reply(Text("quick")).After(time.Millisecond)
+	msgs, _, err := m.Invoke(context.Background(), nil, agentloop.Session{})
+	if err != nil || len(msgs) != 1 {
+		t.Errorf("got %v, %v", msgs, err)
+	}
+}
+
+// TestModelRecordsSnapshot confirms that recorded sessions are not affected
+// by later changes to the caller's session.
+func TestModelRecordsSnapshot(t *testing.T) {
+	m := NewModel()
+	m.Reply(Text("ok"))
+
+	s := agentloop.InitSession("sys", "u")
+	m.Invoke(context.Background(), nil, s)
+	s.Messages[1] = agentloop.UserMessage{Content: "changed"}
+
+	if got := m.Calls()[0].Session.Messages[1]; got != (agentloop.UserMessage{Content: "u"}) {
+		t.Errorf("recorded session mutated: %v", got)
+	}
+}
+
+func TestModelRecordsToolChoice(t *testing.T) {
+	m := NewModel()
+	m.Reply(ToolCall("c1", "plan", map[string]any{}))
+	m.Reply(Text("done"))
+
+	plan := agentloop.Tool{
+		Definition: agentloop.ToolDefinition{Name: "plan", InputSchema: agentloop.ToolInputSchema{Type: "object"}},
+		Handler:    func(context.Context, json.RawMessage) (string, error) { return "ok", nil },
+	}
+	_, err := agentloop.AgentLoop(context.Background(), m.Invoke, []agentloop.Tool{plan},
+		agentloop.InitSession("sys", "u"), agentloop.WithToolChoicePolicy(agentloop.ForceToolFirst("plan")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := m.Calls()
+	if calls[0].ToolChoice != agentloop.ToolChoiceTool("plan") || !calls[1].ToolChoice.IsZero() {
+		t.Errorf("tool choices: %+v, %+v", calls[0].ToolChoice, calls[1].ToolChoice)
+	}
+}
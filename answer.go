@@ -0,0 +1,152 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// SubmitAnswerTool is the name of the terminal tool injected by AgentLoopAnswer
+// and AgentLoopAnswerSchema.
+const SubmitAnswerTool = "submit_answer"
+
+// ErrNoAnswer is returned when a structured-answer loop stops (for example
+// because a usage checker halted it) before a valid answer was submitted.
+var ErrNoAnswer = errors.New("agent loop ended without a valid submitted answer")
+
+// answerReminder re-prompts a model that replied in text instead of
+// submitting its answer.
+const answerReminder = "Submit your final answer by calling the " + SubmitAnswerTool + " tool."
+
+// answerSpec is the terminal submit_answer tool of a structured-answer loop.
+type answerSpec struct {
+	def    ToolDefinition
+	schema map[string]any              // normalized for validation
+	decode func(json.RawMessage) error // extra check that the input decodes
+
+	mu    sync.Mutex
+	value json.RawMessage // first accepted submission
+}
+
+func newAnswerSpec(schema ToolInputSchema, decode func(json.RawMessage) error) (*answerSpec, error) {
+	norm, err := normalizeSchema(schema)
+	if err != nil {
+		return nil, fmt.Errorf("answer schema: %w", err)
+	}
+	return &answerSpec{
+		def: ToolDefinition{
+			Name: SubmitAnswerTool,
+			Description: "Submit your final answer. Call this once you have finished; " +
+				"the conversation ends when a valid answer is submitted.",
+			InputSchema: schema,
+		},
+		schema: norm,
+		decode: decode,
+	}, nil
+}
+
+// handle is the submit_answer tool handler.  Invalid submissions are
+// returned as errors so the model sees why and can try again.
+func (a *answerSpec) handle(_ context.Context, input json.RawMessage) (string, error) {
+	if err := validateJSON(a.schema, input); err != nil {
+		return "", fmt.Errorf("answer does not match the schema:\n%v\nCall %s again with a corrected answer", err, SubmitAnswerTool)
+	}
+	if a.decode != nil {
+		if err := a.decode(input); err != nil {
+			return "", fmt.Errorf("answer could not be decoded: %v\nCall %s again with a corrected answer", err, SubmitAnswerTool)
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.value == nil {
+		a.value = append(json.RawMessage(nil), input...)
+	}
+	return "Answer accepted.", nil
+}
+
+// accepted returns the first valid submission, or nil.
+func (a *answerSpec) accepted() json.RawMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.value
+}
+
+// reject discards the accepted submission, so the loop waits for another.
+func (a *answerSpec) reject() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.value = nil
+}
+
+// withAnswer enables the submit_answer tool for a single loop.
+func withAnswer(a *answerSpec) AgentLoopOption {
+	return func(c *agentLoopConfig) { c.answer = a }
+}
+
+// AgentLoopAnswerSchema runs AgentLoop with an extra submit_answer tool whose
+// input must match schema.  The loop ends as soon as a valid answer is
+// submitted; invalid submissions are returned to the model as tool errors
+// and a text reply without a submission is answered with a reminder.  The
+// accepted tool input is returned as raw JSON.
+func AgentLoopAnswerSchema(ctx context.Context, invokeModel InvokeModelFunc, tools []Tool, session Session, schema ToolInputSchema, opts ...AgentLoopOption) (json.RawMessage, Session, error) {
+	spec, err := newAnswerSpec(schema, nil)
+	if err != nil {
+		return nil, session, err
+	}
+	return runAnswerLoop(ctx, invokeModel, tools, session, spec, opts)
+}
+
+// AgentLoopAnswer is AgentLoopAnswerSchema with the schema derived from T by
+// SchemaFor, returning the submitted answer decoded into a T.
+func AgentLoopAnswer[T any](ctx context.Context, invokeModel InvokeModelFunc, tools []Tool, session Session, opts ...AgentLoopOption) (T, Session, error) {
+	var zero T
+	schema, wrapped := schemaFor(reflect.TypeFor[T]())
+	decode := func(input json.RawMessage) (T, error) {
+		var v T
+		if wrapped {
+			var w struct {
+				Answer json.RawMessage `json:"answer"`
+			}
+			if err := json.Unmarshal(input, &w); err != nil {
+				return v, err
+			}
+			input = w.Answer
+		}
+		err := json.Unmarshal(input, &v)
+		return v, err
+	}
+
+	spec, err := newAnswerSpec(schema, func(input json.RawMessage) error {
+		_, err := decode(input)
+		return err
+	})
+	if err != nil {
+		return zero, session, err
+	}
+	raw, session, err := runAnswerLoop(ctx, invokeModel, tools, session, spec, opts)
+	if err != nil {
+		return zero, session, err
+	}
+	v, err := decode(raw)
+	return v, session, err
+}
+
+func runAnswerLoop(ctx context.Context, invokeModel InvokeModelFunc, tools []Tool, session Session, spec *answerSpec, opts []AgentLoopOption) (json.RawMessage, Session, error) {
+	for _, t := range tools {
+		if t.Definition.Name == SubmitAnswerTool {
+			return nil, session, fmt.Errorf("tool name %q is reserved for structured answers", SubmitAnswerTool)
+		}
+	}
+	session, err := AgentLoop(ctx, invokeModel, tools, session, append(opts[:len(opts):len(opts)], withAnswer(spec))...)
+	if err != nil {
+		return nil, session, err
+	}
+	raw := spec.accepted()
+	if raw == nil {
+		return nil, session, ErrNoAnswer
+	}
+	return raw, session, nil
+}
@@ -0,0 +1,198 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type gradeAnswer struct {
+	Grade       string   `json:"grade" enum:"low,medium,high" description:"How interesting the fact is."`
+	Score       int      `json:"score"`
+	Tags        []string `json:"tags,omitempty"`
+	Explanation string   `json:"explanation,omitempty"`
+}
+
+// scriptedModel returns each response in turn and records the tool names
+// offered on every call.
+func scriptedModel(responses ...[]Message) (InvokeModelFunc, *[][]string) {
+	var offered [][]string
+	i := 0
+	return func(_ context.Context, defs []ToolDefinition, _ Session) ([]Message, Usage, error) {
+		var names []string
+		for _, d := range defs {
+			names = append(names, d.Name)
+		}
+		offered = append(offered, names)
+		if i >= len(responses) {
+			return []Message{AssistantMessage{"done"}}, Usage{InputTokens: 1}, nil
+		}
+		i++
+		return responses[i-1], Usage{InputTokens: 1}, nil
+	}, &offered
+}
+
+func submit(id, input string) ToolCallMessage {
+	return ToolCallMessage{ID: id, Name: SubmitAnswerTool, Input: json.RawMessage(input)}
+}
+
+// TestAgentLoopAnswer walks through a text reply, an invalid submission and
+// a valid one, checking the re-prompts the model receives along the way.
+func TestAgentLoopAnswer(t *testing.T) {
+	model, offered := scriptedModel(
+		[]Message{AssistantMessage{"It is high."}},
+		[]Message{submit("a1", `{"grade":"extreme","score":"9"}`)},
+		[]Message{ToolCallMessage{ID: "n1", Name: "noop", Input: json.RawMessage(`{}`)}, submit("a2", `{"grade":"high","score":9}`)},
+	)
+	got, session, err := AgentLoopAnswer[gradeAnswer](context.Background(), model, []Tool{noopTool},
+		InitSession("sys", "Grade this."), WithCompactor(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (gradeAnswer{Grade: "high", Score: 9}); !reflect.DeepEqual(got, want) {
+		t.Errorf("answer = %+v, want %+v", got, want)
+	}
+	if len(*offered) != 3 || !reflect.DeepEqual((*offered)[0], []string{"noop", SubmitAnswerTool}) {
+		t.Errorf("offered tools: %v", *offered)
+	}
+	if err := session.Validate(); err != nil {
+		t.Errorf("final session invalid: %v", err)
+	}
+
+	if u, ok := session.Messages[3].(UserMessage); !ok || u.Content != answerReminder {
+		t.Errorf("message 3 = %#v, want reminder", session.Messages[3])
+	}
+	rejected := session.Messages[5].(ToolResultMessage).Output
+	for _, want := range []string{"Error: answer does not match the schema", `$.grade: value "extreme" is not one of`, "$.score: got string, want integer"} {
+		if !strings.Contains(rejected, want) {
+			t.Errorf("rejection %q missing %q", rejected, want)
+		}
+	}
+	if last := session.Messages[len(session.Messages)-1].(ToolResultMessage); last.ID != "a2" || last.Output != "Answer accepted." {
+		t.Errorf("last message = %#v", last)
+	}
+}
+
+// TestAgentLoopAnswerWrapped checks that a non-object answer type is
+// submitted under an "answer" property and unwrapped on return.
+func TestAgentLoopAnswerWrapped(t *testing.T) {
+	model, _ := scriptedModel([]Message{submit("a1", `{"answer":[1,2,3]}`)})
+	got, _, err := AgentLoopAnswer[[]int](context.Background(), model, nil, InitSession("sys", "u"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestAgentLoopAnswerSchema(t *testing.T) {
+	schema := ToolInputSchema{
+		Type:       "object",
+		Properties: map[string]any{"city": map[string]any{"type": "string", "minLength": 2}},
+		Required:   []string{"city"},
+	}
+	model, _ := scriptedModel(
+		[]Message{submit("a1", `{"city":"X"}`)},
+		[]Message{submit("a2", `{"city":"Paris"}`)},
+	)
+	raw, _, err := AgentLoopAnswerSchema(context.Background(), model, nil, InitSession("sys", "u"), schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != `{"city":"Paris"}` {
+		t.Errorf("got %s", raw)
+	}
+}
+
+func TestAgentLoopAnswerErrors(t *testing.T) {
+	ctx := context.Background()
+
+	// A model that never submits exhausts the iteration budget.
+	model, _ := scriptedModel()
+	_, _, err := AgentLoopAnswer[gradeAnswer](ctx, model, nil, InitSession("sys", "u"), WithMaxIterations(3))
+	if err == nil || !strings.Contains(err.Error(), "maximum iterations") {
+		t.Errorf("got %v, want max iterations error", err)
+	}
+
+	// A usage checker halting the loop yields ErrNoAnswer.
+	model, _ = scriptedModel([]Message{AssistantMessage{"no"}})
+	_, _, err = AgentLoopAnswer[gradeAnswer](ctx, model, nil, InitSession("sys", "u"),
+		WithUsageChecker(func(u Usage) bool { return u.InputTokens > 0 }))
+	if !errors.Is(err, ErrNoAnswer) {
+		t.Errorf("got %v, want ErrNoAnswer", err)
+	}
+
+	// The tool name is reserved.
+	reserved := Tool{Definition: ToolDefinition{Name: SubmitAnswerTool}}
+	if _, _, err := AgentLoopAnswer[gradeAnswer](ctx, model, []Tool{reserved}, InitSession("sys", "u")); err == nil {
+		t.Error("expected error for a reserved tool name")
+	}
+}
+
+func TestSchemaFor(t *testing.T) {
+	type inner struct {
+		N float64 `json:"n"`
+	}
+	type outer struct {
+		inner
+		Name    string            `json:"name"`
+		Items   []inner           `json:"items,omitempty"`
+		Labels  map[string]string `json:"labels,omitempty"`
+		Skip    string            `json:"-"`
+		private int
+	}
+	got := SchemaFor[outer]()
+	data, _ := json.Marshal(got)
+	want := `{"type":"object","properties":{"items":{"items":{"properties":{"n":{"type":"number"}},"required":["n"],"type":"object"},"type":"array"},"labels":{"additionalProperties":{"type":"string"},"type":"object"},"n":{"type":"number"},"name":{"type":"string"}},"required":["n","name"]}`
+	if string(data) != want {
+		t.Errorf("SchemaFor:\n got %s\nwant %s", data, want)
+	}
+
+	wrapped := SchemaFor[bool]()
+	if wrapped.Required[0] != "answer" || wrapped.Properties["answer"].(map[string]any)["type"] != "boolean" {
+		t.Errorf("wrapped schema: %+v", wrapped)
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	schema, err := normalizeSchema(ToolInputSchema{
+		Type: "object",
+		Properties: map[string]any{
+			"n":    map[string]any{"type": "integer", "minimum": 0, "maximum": 10},
+			"tags": map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "maxItems": 2},
+			"id":   map[string]any{"type": []string{"string", "null"}, "pattern": "^[a-z]+$"},
+		},
+		Required: []string{"n"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		input string
+		want  string // substring of the error; empty for valid input
+	}{
+		{`{"n":3}`, ""},
+		{`{"n":3.0,"id":null,"tags":["a"]}`, ""},
+		{`{}`, `missing required property "n"`},
+		{`{"n":11}`, "$.n: 11 is greater than 10"},
+		{`{"n":1.5}`, "$.n: got number, want integer"},
+		{`{"n":1,"tags":["a",2,"c"]}`, "$.tags: has 3 items"},
+		{`{"n":1,"tags":["a",2]}`, "$.tags[1]: got integer, want string"},
+		{`{"n":1,"id":"ABC"}`, "does not match pattern"},
+		{`[1]`, "$: got array, want object"},
+		{`{`, "invalid JSON"},
+	}
+	for _, tt := range tests {
+		err := validateJSON(schema, json.RawMessage(tt.input))
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.input, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: got %v, want error containing %q", tt.input, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package agentloop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrBudgetExceeded is matched (via errors.Is) by the *BudgetExceededError
+// AgentLoop returns when a budget in its context is spent.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// BudgetExceededError reports which budget ran out.
+type BudgetExceededError struct {
+	Budget string // slash-separated path from the root budget
+	Reason string
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("budget %q exceeded: %s", e.Budget, e.Reason)
+}
+
+func (e *BudgetExceededError) Is(target error) bool { return target == ErrBudgetExceeded }
+
+// BudgetLimits caps a budget.  Zero fields mean no limit.
+type BudgetLimits struct {
+	Tokens  int64   // all tokens: input, output, cache writes and cache reads
+	Dollars float64 // requires Pricing, here or inherited
+	Pricing Pricing // inherited from the parent budget when zero
+}
+
+// Budget tracks usage shared by every AgentLoop run under a context,
+// including loops nested inside tool handlers.  Budgets form a tree: usage
+// debited to a child also counts against each ancestor, so per-subagent
+// caps sit within a global cap.  Usage is priced when it is debited, at
+// the pricing of the budget it is debited to, so a cheap subagent's spend
+// counts at its own rates against an ancestor's dollar cap.  A Budget is
+// safe for concurrent use.
+type Budget struct {
+	name   string
+	parent *Budget
+	limits BudgetLimits
+
+	mu       sync.Mutex
+	used     Usage
+	spent    float64
+	children []*Budget
+}
+
+// NewBudget returns a root budget.
+func NewBudget(name string, limits BudgetLimits) *Budget {
+	return &Budget{name: name, limits: limits}
+}
+
+// Child returns a new budget nested under b.  Each call creates a separate
+// child, so a subagent run once per tool call gets a fresh cap each time.
+func (b *Budget) Child(name string, limits BudgetLimits) *Budget {
+	if limits.Pricing == (Pricing{}) {
+		limits.Pricing = b.limits.Pricing
+	}
+	c := &Budget{name: name, parent: b, limits: limits}
+	b.mu.Lock()
+	b.children = append(b.children, c)
+	b.mu.Unlock()
+	return c
+}
+
+// Path returns the slash-separated names from the root to b.
+func (b *Budget) Path() string {
+	if b.parent == nil {
+		return b.name
+	}
+	return b.parent.Path() + "/" + b.name
+}
+
+// Debit records usage, and its cost at b's pricing, against b and all of
+// its ancestors.
+func (b *Budget) Debit(u Usage) {
+	cost := b.limits.Pricing.Cost(u)
+	for n := b; n != nil; n = n.parent {
+		n.mu.Lock()
+		n.used = n.used.Add(u)
+		n.spent += cost
+		n.mu.Unlock()
+	}
+}
+
+// Used returns the usage debited to b, including its descendants.
+func (b *Budget) Used() Usage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Cost returns the dollar cost of the usage debited to b, including its
+// descendants, each priced at the budget it was debited to.
+func (b *Budget) Cost() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.spent
+}
+
+func totalTokens(u Usage) int64 {
+	return u.InputTokens + u.OutputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+}
+
+// Check returns a *BudgetExceededError if b or any ancestor has reached a
+// limit, naming the innermost such budget.  A dollar limit on a budget with
+// no pricing could never be reached, so Check reports it as an error
+// instead of letting the loop run unbounded.
+func (b *Budget) Check() error {
+	for n := b; n != nil; n = n.parent {
+		if n.limits.Dollars > 0 && n.limits.Pricing == (Pricing{}) {
+			return fmt.Errorf("budget %q has a dollar limit but no pricing", n.Path())
+		}
+		used, cost := n.Used(), n.Cost()
+		if lim := n.limits.Tokens; lim > 0 && totalTokens(used) >= lim {
+			return &BudgetExceededError{Budget: n.Path(), Reason: fmt.Sprintf("%d of %d tokens used", totalTokens(used), lim)}
+		}
+		if lim := n.limits.Dollars; lim > 0 && cost >= lim {
+			return &BudgetExceededError{Budget: n.Path(), Reason: fmt.Sprintf("$%.4f of $%.4f spent", cost, lim)}
+		}
+	}
+	return nil
+}
+
+// BudgetReport is a snapshot of a budget tree.
+type BudgetReport struct {
+	Name     string
+	Usage    Usage
+	Cost     float64
+	Limits   BudgetLimits
+	Children []BudgetReport
+}
+
+// Report returns a snapshot of b and its descendants.
+func (b *Budget) Report() BudgetReport {
+	b.mu.Lock()
+	r := BudgetReport{Name: b.name, Usage: b.used, Cost: b.spent, Limits: b.limits}
+	children := append([]*Budget(nil), b.children...)
+	b.mu.Unlock()
+	for _, c := range children {
+		r.Children = append(r.Children, c.Report())
+	}
+	return r
+}
+
+// String renders the report as an indented tree, one budget per line.
+func (r BudgetReport) String() string {
+	var sb strings.Builder
+	var write func(r BudgetReport, depth int)
+	write = func(r BudgetReport, depth int) {
+		fmt.Fprintf(&sb, "%s%s: %d tokens (%d in, %d out", strings.Repeat("  ", depth), r.Name,
+			totalTokens(r.Usage), r.Usage.InputTokens, r.Usage.OutputTokens)
+		if c := r.Usage.CacheCreationInputTokens + r.Usage.CacheReadInputTokens; c > 0 {
+			fmt.Fprintf(&sb, ", %d cache", c)
+		}
+		sb.WriteString(")")
+		if r.Limits.Pricing != (Pricing{}) || r.Cost > 0 {
+			fmt.Fprintf(&sb, ", $%.4f", r.Cost)
+		}
+		if r.Limits.Tokens > 0 {
+			fmt.Fprintf(&sb, ", limit %d tokens", r.Limits.Tokens)
+		}
+		if r.Limits.Dollars > 0 {
+			fmt.Fprintf(&sb, ", limit $%.4f", r.Limits.Dollars)
+		}
+		sb.WriteString("\n")
+		for _, c := range r.Children {
+			write(c, depth+1)
+		}
+	}
+	write(r, 0)
+	return sb.String()
+}
+
+type budgetKey struct{}
+
+// ContextWithBudget returns a context carrying b.  Every AgentLoop run with
+// the context, and every loop nested in its tool handlers, debits b and
+// stops with a *BudgetExceededError once b or an ancestor is spent.
+func ContextWithBudget(ctx context.Context, b *Budget) context.Context {
+	return context.WithValue(ctx, budgetKey{}, b)
+}
+
+// BudgetFromContext returns the budget stored by ContextWithBudget.
+func BudgetFromContext(ctx context.Context) (*Budget, bool) {
+	b, ok := ctx.Value(budgetKey{}).(*Budget)
+	return b, ok
+}
+
+// WithSubBudget gives the loop its own budget named name, nested under the
+// budget in the context (or a new root if there is none), for the duration
+// of the call.  Tool handlers see the sub-budget in their context, so
+// subagents they start nest beneath it.
+func WithSubBudget(name string, limits BudgetLimits) AgentLoopOption {
+	return func(c *agentLoopConfig) {
+		c.subBudget = &subBudget{name: name, limits: limits}
+	}
+}
+
+type subBudget struct {
+	name   string
+	limits BudgetLimits
+}
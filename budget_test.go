@@ -0,0 +1,116 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// toolCaller returns a model that always calls the named tool, reporting
+// 100 input tokens per call.
+func toolCaller(name string) InvokeModelFunc {
+	n := 0
+	return func(context.Context, []ToolDefinition, Session) ([]Message, Usage, error) {
+		n++
+		return []Message{ToolCallMessage{ID: fmt.Sprintf("%s%d", name, n), Name: name, Input: json.RawMessage(`{}`)}},
+			Usage{InputTokens: 100}, nil
+	}
+}
+
+// TestBudgetNested runs a parent loop whose tool starts a capped subagent
+// and checks that both caps are enforced and usage is attributed.
+func TestBudgetNested(t *testing.T) {
+	var subErrs []error
+	delegate := Tool{
+		Definition: ToolDefinition{Name: "delegate", InputSchema: ToolInputSchema{Type: "object"}},
+		Handler: func(ctx context.Context, _ json.RawMessage) (string, error) {
+			_, err := AgentLoop(ctx, toolCaller("noop"), []Tool{noopTool}, InitSession("sub", "go"),
+				WithSubBudget("delegate", BudgetLimits{Tokens: 150}))
+			subErrs = append(subErrs, err)
+			return "", err
+		},
+	}
+
+	root := NewBudget("run", BudgetLimits{Tokens: 450, Pricing: Pricing{Input: 10}})
+	ctx := ContextWithBudget(context.Background(), root)
+	_, err := AgentLoop(ctx, toolCaller("delegate"), []Tool{delegate}, InitSession("sys", "u"))
+
+	var be *BudgetExceededError
+	if !errors.As(err, &be) || !errors.Is(err, ErrBudgetExceeded) || be.Budget != "run" {
+		t.Fatalf("got %v, want the run budget exceeded", err)
+	}
+	if len(subErrs) != 2 {
+		t.Fatalf("subagent ran %d times, want 2", len(subErrs))
+	}
+	if !errors.As(subErrs[0], &be) || be.Budget != "run/delegate" {
+		t.Errorf("first subagent: got %v, want its own cap exceeded", subErrs[0])
+	}
+	if !errors.As(subErrs[1], &be) || be.Budget != "run" {
+		t.Errorf("second subagent: got %v, want the global cap exceeded", subErrs[1])
+	}
+
+	r := root.Report()
+	if r.Usage.InputTokens != 500 || len(r.Children) != 2 ||
+		r.Children[0].Usage.InputTokens != 200 || r.Children[1].Usage.InputTokens != 100 {
+		t.Errorf("report:\n%s", r)
+	}
+	if root.Cost() != 0.005 || r.Children[0].Cost != 0.002 {
+		t.Errorf("cost: root %v, child %v", root.Cost(), r.Children[0].Cost)
+	}
+	want := "run: 500 tokens (500 in, 0 out), $0.0050, limit 450 tokens\n  delegate: 200 tokens (200 in, 0 out), $0.0020, limit 150 tokens\n"
+	if !strings.HasPrefix(r.String(), want) {
+		t.Errorf("String():\n%s\nwant prefix:\n%s", r, want)
+	}
+}
+
+func TestBudgetDollars(t *testing.T) {
+	b := NewBudget("run", BudgetLimits{Dollars: 0.01, Pricing: Pricing{Output: 10}})
+	b.Debit(Usage{OutputTokens: 999})
+	if err := b.Check(); err != nil {
+		t.Errorf("unexpected %v", err)
+	}
+	b.Child("sub", BudgetLimits{}).Debit(Usage{OutputTokens: 1})
+	if err := b.Check(); !errors.Is(err, ErrBudgetExceeded) || !strings.Contains(err.Error(), "$0.0100 of $0.0100") {
+		t.Errorf("got %v", err)
+	}
+}
+
+// TestBudgetMixedPricing checks that a child's usage is priced at the
+// child's rates when it counts against its parent.
+func TestBudgetMixedPricing(t *testing.T) {
+	opus, haiku := DefaultPrices["claude-opus-4-1"], DefaultPrices["claude-haiku-4-5"]
+	root := NewBudget("run", BudgetLimits{Dollars: 1, Pricing: opus})
+	sub := root.Child("search", BudgetLimits{Pricing: haiku})
+	sub.Debit(Usage{InputTokens: 100_000, OutputTokens: 10_000}) // $0.15 at Haiku rates, $2.25 at Opus
+	root.Debit(Usage{InputTokens: 10_000})                       // $0.15 at Opus rates
+
+	if got := sub.Cost(); math.Abs(got-0.15) > 1e-9 {
+		t.Errorf("child cost %v, want 0.15", got)
+	}
+	if got := root.Cost(); math.Abs(got-0.30) > 1e-9 {
+		t.Errorf("root cost %v, want 0.30", got)
+	}
+	if r := root.Report(); math.Abs(r.Cost-0.30) > 1e-9 || math.Abs(r.Children[0].Cost-0.15) > 1e-9 {
+		t.Errorf("report:\n%s", r)
+	}
+	if err := sub.Check(); err != nil {
+		t.Errorf("unexpected %v", err)
+	}
+}
+
+func TestBudgetDollarsWithoutPricing(t *testing.T) {
+	root := NewBudget("run", BudgetLimits{})
+	sub := root.Child("sub", BudgetLimits{Dollars: 1})
+	err := sub.Check()
+	if err == nil || errors.Is(err, ErrBudgetExceeded) || !strings.Contains(err.Error(), "no pricing") {
+		t.Errorf("got %v, want a missing pricing error", err)
+	}
+	_, err = AgentLoop(ContextWithBudget(context.Background(), sub), toolCaller("noop"), []Tool{noopTool}, InitSession("sys", "u"))
+	if err == nil || !strings.Contains(err.Error(), "no pricing") {
+		t.Errorf("AgentLoop: got %v", err)
+	}
+}
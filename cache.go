@@ -0,0 +1,115 @@
+package agentloop
+
+import (
+	anthropic "github.com/anthropics/anthropic-sdk-go"
+)
+
+// maxCacheBreakpoints is the API's limit on cache_control markers per
+// request.
+const maxCacheBreakpoints = 4
+
+// CacheTTL is the lifetime of a prompt-cache entry.  The empty value uses
+// the API default of five minutes.
+type CacheTTL string
+
+const (
+	CacheTTL5m CacheTTL = "5m"
+	CacheTTL1h CacheTTL = "1h" // costs more to write; worth it for long gaps between turns
+)
+
+// CacheStrategy places prompt-cache breakpoints on a request before it is
+// sent.  Strategies may set cache_control on any system block, tool or
+// message content block; breakpoints beyond the API limit of four are
+// removed afterwards, earliest first.
+type CacheStrategy func(params *anthropic.MessageNewParams)
+
+// WithCaching sets the prompt caching strategy.  Chat and InvokeClaude
+// default to CacheStatic(""); Complete defaults to CacheNone.
+func WithCaching(s CacheStrategy) Option {
+	return func(c *completeConfig) { c.cache = s }
+}
+
+// CacheNone sends no cache breakpoints.
+func CacheNone() CacheStrategy {
+	return func(*anthropic.MessageNewParams) {}
+}
+
+// CacheStatic marks the last system block and the last tool, caching the
+// parts of the prompt that stay the same across turns.
+func CacheStatic(ttl CacheTTL) CacheStrategy {
+	return func(p *anthropic.MessageNewParams) {
+		if n := len(p.System); n > 0 {
+			p.System[n-1].CacheControl = cacheControl(ttl)
+		}
+		if n := len(p.Tools); n > 0 {
+			if cc := p.Tools[n-1].GetCacheControl(); cc != nil {
+				*cc = cacheControl(ttl)
+			}
+		}
+	}
+}
+
+// CacheRolling extends CacheStatic with breakpoints on the last block of
+// the most recent turns, up to turns of them and within the four-breakpoint
+// limit, so each request in a long loop reads the transcript so far from
+// the cache and writes only the newest turns.
+func CacheRolling(turns int, ttl CacheTTL) CacheStrategy {
+	static := CacheStatic(ttl)
+	return func(p *anthropic.MessageNewParams) {
+		static(p)
+		budget := min(turns, maxCacheBreakpoints-countBreakpoints(p))
+		for i := len(p.Messages) - 1; i >= 0 && budget > 0; i-- {
+			blocks := p.Messages[i].Content
+			if len(blocks) == 0 {
+				continue
+			}
+			if cc := blocks[len(blocks)-1].GetCacheControl(); cc != nil {
+				*cc = cacheControl(ttl)
+				budget--
+			}
+		}
+	}
+}
+
+func cacheControl(ttl CacheTTL) anthropic.CacheControlEphemeralParam {
+	cc := anthropic.NewCacheControlEphemeralParam()
+	cc.TTL = anthropic.CacheControlEphemeralTTL(ttl)
+	return cc
+}
+
+// breakpoints returns pointers to every cache_control slot in the request
+// that is set, in prompt order (tools, system, messages).
+func breakpoints(p *anthropic.MessageNewParams) []*anthropic.CacheControlEphemeralParam {
+	var out []*anthropic.CacheControlEphemeralParam
+	add := func(cc *anthropic.CacheControlEphemeralParam) {
+		if cc != nil && cc.Type != "" {
+			out = append(out, cc)
+		}
+	}
+	for i := range p.Tools {
+		add(p.Tools[i].GetCacheControl())
+	}
+	for i := range p.System {
+		add(&p.System[i].CacheControl)
+	}
+	for i := range p.Messages {
+		for j := range p.Messages[i].Content {
+			add(p.Messages[i].Content[j].GetCacheControl())
+		}
+	}
+	return out
+}
+
+func countBreakpoints(p *anthropic.MessageNewParams) int {
+	return len(breakpoints(p))
+}
+
+// limitBreakpoints clears the earliest breakpoints until at most
+// maxCacheBreakpoints remain.  Later breakpoints cover the prefix before
+// them, so dropping early ones loses the least.
+func limitBreakpoints(p *anthropic.MessageNewParams) {
+	bps := breakpoints(p)
+	for _, cc := range bps[:max(0, len(bps)-maxCacheBreakpoints)] {
+		*cc = anthropic.CacheControlEphemeralParam{}
+	}
+}
@@ -0,0 +1,112 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	anthropic "github.com/anthropics/anthropic-sdk-go"
+)
+
+// cachedPaths builds a Chat request for a five-turn session and returns the
+// location and TTL of every cache breakpoint.
+func cachedPaths(t *testing.T, opts ...Option) map[string]string {
+	t.Helper()
+	s := InitSession("sys", "first")
+	s.Add(
+		ToolCallMessage{ID: "c1", Name: "noop", Input: json.RawMessage(`{}`)},
+		ToolResultMessage{ID: "c1", Output: "ok"},
+		AssistantMessage{"second"},
+		UserMessage{"third"},
+	)
+	tools := WithTools(ToolDefinition{Name: "a", InputSchema: ToolInputSchema{Type: "object"}},
+		ToolDefinition{Name: "b", InputSchema: ToolInputSchema{Type: "object"}})
+	cfg := (&Claude{}).config(4096, append([]Option{tools}, opts...))
+	system, messages := buildParams(s)
+	params := cfg.params(context.Background(), system, messages, CacheStatic(""))
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req struct {
+		System []struct {
+			CacheControl map[string]string `json:"cache_control"`
+		}
+		Tools []struct {
+			Name         string
+			CacheControl map[string]string `json:"cache_control"`
+		}
+		Messages []struct {
+			Content []struct {
+				CacheControl map[string]string `json:"cache_control"`
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+
+	out := map[string]string{}
+	mark := func(path string, cc map[string]string) {
+		if cc != nil {
+			out[path] = cc["ttl"]
+		}
+	}
+	for _, b := range req.System {
+		mark("system", b.CacheControl)
+	}
+	for _, tool := range req.Tools {
+		mark("tool "+tool.Name, tool.CacheControl)
+	}
+	for i, m := range req.Messages {
+		for j, b := range m.Content {
+			mark(fmt.Sprintf("message %d.%d", i, j), b.CacheControl)
+		}
+	}
+	return out
+}
+
+func TestCacheStrategies(t *testing.T) {
+	// Turns: 0 user(first), 1 assistant(tool_use), 2 user(tool_result),
+	// 3 assistant(second), 4 user(third).
+	tests := []struct {
+		name string
+		opts []Option
+		want map[string]string
+	}{
+		{"default", nil, map[string]string{"system": "", "tool b": ""}},
+		{"none", []Option{WithCaching(CacheNone())}, map[string]string{}},
+		{"static 1h", []Option{WithCaching(CacheStatic(CacheTTL1h))},
+			map[string]string{"system": "1h", "tool b": "1h"}},
+		{"rolling", []Option{WithCaching(CacheRolling(1, CacheTTL5m))},
+			map[string]string{"system": "5m", "tool b": "5m", "message 4.0": "5m"}},
+		{"rolling capped", []Option{WithCaching(CacheRolling(10, ""))},
+			map[string]string{"system": "", "tool b": "", "message 4.0": "", "message 3.0": ""}},
+	}
+	for _, tt := range tests {
+		if got := cachedPaths(t, tt.opts...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestCacheBreakpointLimit checks that a custom strategy marking every
+// message keeps only the last four breakpoints.
+func TestCacheBreakpointLimit(t *testing.T) {
+	everything := func(p *anthropic.MessageNewParams) {
+		CacheStatic("")(p)
+		for i := range p.Messages {
+			for j := range p.Messages[i].Content {
+				*p.Messages[i].Content[j].GetCacheControl() = anthropic.NewCacheControlEphemeralParam()
+			}
+		}
+	}
+	got := cachedPaths(t, WithCaching(everything))
+	want := map[string]string{"message 1.0": "", "message 2.0": "", "message 3.0": "", "message 4.0": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
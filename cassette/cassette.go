@@ -0,0 +1,337 @@
+// Package cassette records Anthropic API HTTP exchanges to a file and
+// replays them offline.
+//
+// A Recorder is injected into a client through its RequestOption:
+//
+//	rec, err := cassette.New("testdata/cassettes/multi_turn.json", cassette.ModeAuto)
+//	if err != nil { ... }
+//	defer rec.Save()
+//	client := agentloop.NewClaude(rec.Option())
+//
+// Requests are matched on method, path and JSON body; headers are ignored
+// for matching, volatile request headers and all but a few response headers
+// are not recorded, and credentials are redacted before anything is written
+// to disk.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// Mode selects whether a Recorder talks to the network.
+type Mode int
+
+const (
+	// ModeReplay serves every request from the cassette and fails requests
+	// that have no recorded match.  The network is never used.
+	ModeReplay Mode = iota
+	// ModeRecord forwards every request to the network and records the
+	// exchange, replacing any existing cassette on Save.
+	ModeRecord
+	// ModeAuto replays if the cassette file exists and records otherwise.
+	ModeAuto
+)
+
+// ErrNoMatch is returned (wrapped) in replay mode when a request has no
+// unused recorded interaction.
+var ErrNoMatch = errors.New("cassette: no recorded interaction matches request")
+
+// redacted replaces the value of credential headers in recorded requests.
+const redacted = "[REDACTED]"
+
+// secretHeaders carry credentials and are recorded as redacted.
+var secretHeaders = []string{"X-Api-Key", "Authorization", "Proxy-Authorization", "Cookie"}
+
+// volatileHeaders change between runs and are not recorded on requests.
+var volatileHeaders = []string{"User-Agent", "Idempotency-Key", "Traceparent"}
+
+// volatilePrefixes match families of volatile request headers.
+var volatilePrefixes = []string{"X-Stainless-"}
+
+// responseHeaders are the only response headers recorded; the rest describe
+// the serving infrastructure and vary per call.
+var responseHeaders = []string{"Content-Type", "Retry-After"}
+
+// Request is the recorded form of an HTTP request.
+type Request struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"` // path and query only; the host is not recorded
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Response is the recorded form of an HTTP response.
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body"`
+}
+
+// Interaction is one recorded request/response pair.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Cassette is the on-disk document.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Recorder captures or replays HTTP exchanges.  It is safe for concurrent
+// use.
+type Recorder struct {
+	path string
+	mode Mode
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+	secrets  []string // credential values seen while recording
+}
+
+// New returns a Recorder for the cassette at path.  In ModeReplay the file
+// must exist; ModeAuto resolves to ModeReplay or ModeRecord depending on
+// whether it does.
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{path: path, mode: mode}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && mode == ModeAuto:
+		r.mode = ModeRecord
+		return r, nil
+	case err != nil && mode != ModeRecord:
+		return nil, err
+	case mode == ModeAuto:
+		r.mode = ModeReplay
+	}
+	if r.mode == ModeRecord {
+		return r, nil
+	}
+
+	if err := json.Unmarshal(data, &r.cassette); err != nil {
+		return nil, fmt.Errorf("cassette %s: %w", path, err)
+	}
+	r.used = make([]bool, len(r.cassette.Interactions))
+	return r, nil
+}
+
+// Mode returns the effective mode, with ModeAuto resolved.
+func (r *Recorder) Mode() Mode { return r.mode }
+
+// Option returns a request option that routes client traffic through the
+// recorder.  Pass it to NewClaude or any Anthropic client constructor.
+func (r *Recorder) Option() option.RequestOption {
+	return option.WithMiddleware(r.middleware)
+}
+
+func (r *Recorder) middleware(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.mode == ModeReplay {
+		return r.replay(req, body)
+	}
+
+	resp, err := next(req)
+	if err != nil {
+		return resp, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, h := range secretHeaders {
+		if v := req.Header.Get(h); v != "" {
+			r.secrets = append(r.secrets, strings.TrimPrefix(v, "Bearer "))
+		}
+	}
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: Request{
+			Method: req.Method,
+			Path:   req.URL.RequestURI(),
+			Header: cleanHeader(req.Header),
+			Body:   string(body),
+		},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     keepHeaders(resp.Header, responseHeaders),
+			Body:       string(respBody),
+		},
+	})
+	return resp, nil
+}
+
+// replay serves the first unused interaction matching req.
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.cassette.Interactions {
+		if r.used[i] || !matches(in.Request, req, body) {
+			continue
+		}
+		r.used[i] = true
+		header := in.Response.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(strings.NewReader(in.Response.Body)),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrNoMatch, req.Method, req.URL.RequestURI())
+}
+
+// Unused returns the number of recorded interactions not yet replayed.
+func (r *Recorder) Unused() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, u := range r.used {
+		if !u {
+			n++
+		}
+	}
+	return n
+}
+
+// Save writes the recorded interactions to the cassette file, creating
+// parent directories as needed.  It does nothing in replay mode.  Any
+// credential value seen in a request header is scrubbed from the whole
+// document, including bodies.
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	secrets := r.secrets
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	out := string(data)
+	for _, s := range secrets {
+		if s != "" {
+			out = strings.ReplaceAll(out, s, redacted)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, []byte(out+"\n"), 0o644)
+}
+
+// readBody reads and restores a request body.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// cleanHeader drops volatile headers and redacts credentials.
+func cleanHeader(h http.Header) http.Header {
+	out := http.Header{}
+	for k, vs := range h {
+		k = http.CanonicalHeaderKey(k)
+		if isVolatile(k) {
+			continue
+		}
+		for _, s := range secretHeaders {
+			if k == s {
+				vs = []string{redacted}
+			}
+		}
+		out[k] = append([]string(nil), vs...)
+	}
+	return out
+}
+
+// keepHeaders returns only the listed headers from h.
+func keepHeaders(h http.Header, keys []string) http.Header {
+	out := http.Header{}
+	for _, k := range keys {
+		if vs := h.Values(k); len(vs) > 0 {
+			out[http.CanonicalHeaderKey(k)] = append([]string(nil), vs...)
+		}
+	}
+	return out
+}
+
+func isVolatile(key string) bool {
+	for _, v := range volatileHeaders {
+		if key == v {
+			return true
+		}
+	}
+	for _, p := range volatilePrefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether a recorded request matches a live one.  Bodies
+// are compared as canonical JSON when both parse, so key order and
+// whitespace do not matter.
+func matches(rec Request, req *http.Request, body []byte) bool {
+	if rec.Method != req.Method || rec.Path != req.URL.RequestURI() {
+		return false
+	}
+	a, errA := canonicalJSON([]byte(rec.Body))
+	b, errB := canonicalJSON(body)
+	if errA != nil || errB != nil {
+		return rec.Body == string(body)
+	}
+	return a == b
+}
+
+// canonicalJSON re-encodes data with sorted keys and no whitespace.  It
+// mirrors the root package's helper, which this package cannot import
+// because that package's tests import this one.
+func canonicalJSON(data []byte) (string, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return "", nil
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", err
+	}
+	out, err := json.Marshal(v)
+	return string(out), err
+}
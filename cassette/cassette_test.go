@@ -0,0 +1,158 @@
+package cassette
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	anthropic "github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+const fakeResponse = `{"id":"msg_1","type":"message","role":"assistant","model":"claude-test",
+"content":[{"type":"text","text":"Hello!"}],"stop_reason":"end_turn",
+"usage":{"input_tokens":12,"output_tokens":3}}`
+
+// newServer returns a fake Messages API that counts requests.
+func newServer(t *testing.T, hits *atomic.Int32) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Request-Id", "req_volatile")
+		io.WriteString(w, fakeResponse)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func send(t *testing.T, rec *Recorder, baseURL, prompt string) (*anthropic.Message, error) {
+	t.Helper()
+	client := anthropic.NewClient(
+		option.WithBaseURL(baseURL),
+		option.WithAPIKey("sk-ant-secret-key"),
+		option.WithMaxRetries(0),
+		rec.Option(),
+	)
+	return client.Messages.New(context.Background(), anthropic.MessageNewParams{
+		Model:     "claude-test",
+		MaxTokens: 16,
+		Messages:  []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock(prompt))},
+	})
+}
+
+// TestRecordThenReplay records one exchange against a fake server, checks
+// the cassette contents, then replays it with the server unreachable.
+func TestRecordThenReplay(t *testing.T) {
+	var hits atomic.Int32
+	srv := newServer(t, &hits)
+	path := filepath.Join(t.TempDir(), "nested", "hello.json")
+
+	rec, err := New(path, ModeAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Mode() != ModeRecord {
+		t.Fatalf("ModeAuto without file: got mode %v, want ModeRecord", rec.Mode())
+	}
+	if _, err := send(t, rec, srv.URL, "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := string(data)
+	if strings.Contains(doc, "sk-ant-secret-key") {
+		t.Error("cassette contains the API key")
+	}
+	if !strings.Contains(doc, redacted) {
+		t.Error("cassette does not record a redacted key header")
+	}
+	for _, volatile := range []string{"X-Stainless-", "req_volatile", "127.0.0.1"} {
+		if strings.Contains(doc, volatile) {
+			t.Errorf("cassette contains volatile value %q", volatile)
+		}
+	}
+
+	// Replay with a server that must not be contacted.
+	srv.Close()
+	replay, err := New(path, ModeAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.Mode() != ModeReplay {
+		t.Fatalf("ModeAuto with file: got mode %v, want ModeReplay", replay.Mode())
+	}
+	msg, err := send(t, replay, "http://unreachable.invalid", "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Content[0].Text != "Hello!" || msg.Usage.InputTokens != 12 {
+		t.Errorf("unexpected replayed message: %+v", msg)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("server hit %d times, want 1", hits.Load())
+	}
+	if replay.Unused() != 0 {
+		t.Errorf("%d interactions unused", replay.Unused())
+	}
+
+	// A second identical request has no unused interaction left.
+	if _, err := send(t, replay, "http://unreachable.invalid", "hi"); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("got %v, want ErrNoMatch", err)
+	}
+}
+
+func TestReplayMismatchedBody(t *testing.T) {
+	var hits atomic.Int32
+	srv := newServer(t, &hits)
+	path := filepath.Join(t.TempDir(), "c.json")
+
+	rec, _ := New(path, ModeRecord)
+	if _, err := send(t, rec, srv.URL, "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := New(path, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := send(t, replay, srv.URL, "different prompt"); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("got %v, want ErrNoMatch", err)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("replay contacted the server")
+	}
+}
+
+func TestReplayMissingFile(t *testing.T) {
+	if _, err := New(filepath.Join(t.TempDir(), "missing.json"), ModeReplay); err == nil {
+		t.Error("expected error for missing cassette in replay mode")
+	}
+}
+
+func TestCanonicalBodyMatch(t *testing.T) {
+	req := httptest.NewRequest("POST", "/v1/messages", nil)
+	rec := Request{Method: "POST", Path: "/v1/messages", Body: `{"b":2,"a":[1, 2]}`}
+	if !matches(rec, req, []byte(`{ "a": [1,2], "b": 2 }`)) {
+		t.Error("equivalent JSON bodies should match")
+	}
+	if matches(rec, req, []byte(`{"a":[2,1],"b":2}`)) {
+		t.Error("different JSON bodies should not match")
+	}
+}
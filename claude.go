@@ -23,11 +23,60 @@ func NewClaude(opts ...option.RequestOption) *Claude {
 
 // completeConfig holds per-request options built by Option functions.
 type completeConfig struct {
-	model     anthropic.Model
-	maxTokens int64
-	system    string
-	thinking  *int64 // budget tokens; nil = disabled
-	tools     []ToolDefinition
+	model      anthropic.Model
+	maxTokens  int64
+	system     string
+	thinking   *int64 // budget tokens; nil = disabled
+	tools      []ToolDefinition
+	toolChoice ToolChoice
+
+	temperature   *float64
+	topP          *float64
+	topK          *int64
+	stopSequences []string
+	userID        string
+	serviceTier   anthropic.MessageNewParamsServiceTier
+	betas         []string
+	onStop        StopFunc
+	cache         CacheStrategy
+}
+
+// applySampling copies the sampling and metadata settings onto params.
+func (c *completeConfig) applySampling(params *anthropic.MessageNewParams) {
+	if c.temperature != nil {
+		params.Temperature = anthropic.Float(*c.temperature)
+	}
+	if c.topP != nil {
+		params.TopP = anthropic.Float(*c.topP)
+	}
+	if c.topK != nil {
+		params.TopK = anthropic.Int(*c.topK)
+	}
+	if len(c.stopSequences) > 0 {
+		params.StopSequences = c.stopSequences
+	}
+	if c.userID != "" {
+		params.Metadata = anthropic.MetadataParam{UserID: anthropic.String(c.userID)}
+	}
+	if c.serviceTier != "" {
+		params.ServiceTier = c.serviceTier
+	}
+}
+
+// requestOptions returns the per-request HTTP options (beta headers).
+func (c *completeConfig) requestOptions() []option.RequestOption {
+	var opts []option.RequestOption
+	for _, b := range c.betas {
+		opts = append(opts, option.WithHeaderAdd("anthropic-beta", b))
+	}
+	return opts
+}
+
+// reportStop passes the response's stop reason to the stop listener.
+func (c *completeConfig) reportStop(resp *anthropic.Message) {
+	if c.onStop != nil {
+		c.onStop(StopInfo{Reason: string(resp.StopReason), Sequence: resp.StopSequence})
+	}
 }
 
 // Option configures a single Complete call.
@@ -59,34 +108,168 @@ func WithTools(tools ...ToolDefinition) Option {
 	return func(c *completeConfig) { c.tools = tools }
 }
 
-// Complete sends a single user message and returns the model's response.
-func (c *Claude) Complete(ctx context.Context, prompt string, opts ...Option) (*anthropic.Message, error) {
+// WithToolChoice sets whether and which tools the model may call.  Forcing
+// a tool ("any" or "tool") is not supported together with WithThinking.
+func WithToolChoice(tc ToolChoice) Option {
+	return func(c *completeConfig) { c.toolChoice = tc }
+}
+
+// WithTemperature sets the sampling temperature (0 to 1).  Use 0 for the
+// most deterministic output, e.g. in evaluations.
+func WithTemperature(t float64) Option {
+	return func(c *completeConfig) { c.temperature = &t }
+}
+
+// WithTopP enables nucleus sampling with the given cumulative probability.
+func WithTopP(p float64) Option {
+	return func(c *completeConfig) { c.topP = &p }
+}
+
+// WithTopK samples only from the k most likely tokens.
+func WithTopK(k int64) Option {
+	return func(c *completeConfig) { c.topK = &k }
+}
+
+// WithStopSequences sets custom sequences that end generation.  Use
+// WithStopListener or StopSequenceHit to find out which one was hit.
+func WithStopSequences(seqs ...string) Option {
+	return func(c *completeConfig) { c.stopSequences = seqs }
+}
+
+// WithUserID sets metadata.user_id, an opaque identifier for the end user
+// on whose behalf the request is made.
+func WithUserID(id string) Option {
+	return func(c *completeConfig) { c.userID = id }
+}
+
+// WithServiceTier selects the service tier ("auto" or "standard_only").
+func WithServiceTier(tier anthropic.MessageNewParamsServiceTier) Option {
+	return func(c *completeConfig) { c.serviceTier = tier }
+}
+
+// WithBeta adds anthropic-beta headers enabling the named beta features.
+// It may be given more than once.
+func WithBeta(features ...string) Option {
+	return func(c *completeConfig) { c.betas = append(c.betas, features...) }
+}
+
+// StopInfo describes why the model stopped generating.
+type StopInfo struct {
+	Reason   string // e.g. "end_turn", "max_tokens", "stop_sequence", "tool_use"
+	Sequence string // the stop sequence hit, when Reason is "stop_sequence"
+}
+
+// StopFunc receives the stop reason of each response.
+type StopFunc func(StopInfo)
+
+// WithStopListener sets a function called with the stop reason of every
+// response.  With InvokeClaude this is the only way to learn that a stop
+// sequence ended a response, since the returned messages do not record it.
+func WithStopListener(fn StopFunc) Option {
+	return func(c *completeConfig) { c.onStop = fn }
+}
+
+// config returns the request configuration for this client with opts
+// applied over the defaults.
+func (c *Claude) config(maxTokens int64, opts []Option) *completeConfig {
 	cfg := &completeConfig{
 		model:     c.model,
-		maxTokens: 1024,
+		maxTokens: maxTokens,
 	}
 	for _, o := range opts {
 		o(cfg)
 	}
+	return cfg
+}
 
+// params builds the request shared by Complete and Chat.  Cache
+// breakpoints are placed by the WithCaching strategy, or by defaultCache if
+// none was given.  A tool choice in ctx (see ContextWithToolChoice)
+// overrides WithToolChoice.
+func (c *completeConfig) params(ctx context.Context, system []anthropic.TextBlockParam, messages []anthropic.MessageParam, defaultCache CacheStrategy) anthropic.MessageNewParams {
 	params := anthropic.MessageNewParams{
-		Model:     cfg.model,
-		MaxTokens: cfg.maxTokens,
-		Messages: []anthropic.MessageParam{
-			anthropic.NewUserMessage(anthropic.NewTextBlock(prompt)),
-		},
+		Model:     c.model,
+		MaxTokens: c.maxTokens,
+		Messages:  messages,
 	}
-	if cfg.system != "" {
-		params.System = []anthropic.TextBlockParam{{Text: cfg.system}}
+	if len(system) > 0 {
+		params.System = system
+	}
+	if c.thinking != nil {
+		params.Thinking = anthropic.ThinkingConfigParamOfEnabled(*c.thinking)
 	}
-	if cfg.thinking != nil {
-		params.Thinking = anthropic.ThinkingConfigParamOfEnabled(*cfg.thinking)
+	if len(c.tools) > 0 {
+		params.Tools = toolDefsToParams(c.tools)
+
+		choice := c.toolChoice
+		if fromCtx, ok := ToolChoiceFromContext(ctx); ok {
+			choice = fromCtx
+		}
+		if tc, ok := toolChoiceParam(choice); ok {
+			params.ToolChoice = tc
+		}
 	}
-	if len(cfg.tools) > 0 {
-		params.Tools = toolDefsToParams(cfg.tools)
+	c.applySampling(&params)
+
+	cache := c.cache
+	if cache == nil {
+		cache = defaultCache
 	}
+	cache(&params)
+	limitBreakpoints(&params)
+	return params
+}
 
-	return c.api.Messages.New(ctx, params)
+// send makes the API call and reports the stop reason.
+func (c *Claude) send(ctx context.Context, cfg *completeConfig, params anthropic.MessageNewParams) (*anthropic.Message, error) {
+	resp, err := c.api.Messages.New(ctx, params, cfg.requestOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	cfg.reportStop(resp)
+	return resp, nil
+}
+
+// Complete sends a single user message and returns the model's response.
+func (c *Claude) Complete(ctx context.Context, prompt string, opts ...Option) (*anthropic.Message, error) {
+	cfg := c.config(1024, opts)
+	var system []anthropic.TextBlockParam
+	if cfg.system != "" {
+		system = []anthropic.TextBlockParam{{Text: cfg.system}}
+	}
+	messages := []anthropic.MessageParam{
+		anthropic.NewUserMessage(anthropic.NewTextBlock(prompt)),
+	}
+	return c.send(ctx, cfg, cfg.params(ctx, system, messages, CacheNone()))
+}
+
+// Chat sends a whole conversation and returns the model's reply as session
+// messages, ready to be added to the session, along with token usage.  The
+// tools offered are set with WithTools; tool calls are returned but not
+// executed (use AgentLoop for that).  A WithSystem prompt is sent before
+// any SystemMessage in the session.  max_tokens defaults to 4096 and the
+// system prompt and tools are cached (see WithCaching).
+//
+// Conversion rules:
+//   - SystemMessage        → params.System (TextBlockParam)
+//   - UserMessage          → user turn, text block
+//   - AssistantMessage     → assistant turn, text block
+//   - ThinkingMessage      → skipped (no API signature; kept in session for display only)
+//   - ToolCallMessage      → assistant turn, tool_use block
+//   - ToolResultMessage    → user turn, tool_result block
+//
+// Consecutive messages of the same role are merged into a single turn.
+func (c *Claude) Chat(ctx context.Context, session Session, opts ...Option) ([]Message, Usage, error) {
+	cfg := c.config(4096, opts)
+	system, messages := buildParams(session)
+	if cfg.system != "" {
+		system = append([]anthropic.TextBlockParam{{Text: cfg.system}}, system...)
+	}
+	resp, err := c.send(ctx, cfg, cfg.params(ctx, system, messages, CacheStatic("")))
+	if err != nil {
+		return nil, Usage{}, err
+	}
+	return responseToMessages(resp), usageOf(resp), nil
 }
 
 // TextContent returns the concatenated text from a message's content blocks.
@@ -100,6 +283,14 @@ func TextContent(msg *anthropic.Message) string {
 	return out
 }
 
+// StopSequenceHit returns the stop sequence that ended msg, if any.
+func StopSequenceHit(msg *anthropic.Message) (string, bool) {
+	if msg.StopReason != anthropic.StopReasonStopSequence {
+		return "", false
+	}
+	return msg.StopSequence, true
+}
+
 // ToolUseBlocks returns all tool_use blocks from a message.
 func ToolUseBlocks(msg *anthropic.Message) []anthropic.ToolUseBlock {
 	var blocks []anthropic.ToolUseBlock
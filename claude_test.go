@@ -2,11 +2,21 @@ package agentloop
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	anthropic "github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+
+	"github.com/tdb-alcorn/agent-loop-go/cassette"
 )
 
 // TestMain loads .env before running any tests.
@@ -23,6 +33,39 @@ func skipIfNoKey(t *testing.T) {
 	}
 }
 
+// cassetteClaude returns a client whose traffic is replayed from
+// testdata/cassettes/<name>.json, so the test runs offline.  If the cassette
+// is missing, or AGENTLOOP_RECORD is set, a new one is recorded against the
+// live API (which requires ANTHROPIC_API_KEY) and saved when the test passes.
+// opts are applied before the recorder, so middleware among them sees every
+// request.
+func cassetteClaude(t *testing.T, name string, opts ...option.RequestOption) *Claude {
+	t.Helper()
+	mode := cassette.ModeAuto
+	if os.Getenv("AGENTLOOP_RECORD") != "" {
+		mode = cassette.ModeRecord
+	}
+	rec, err := cassette.New(filepath.Join("testdata", "cassettes", name+".json"), mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Mode() == cassette.ModeRecord {
+		skipIfNoKey(t)
+	}
+	t.Cleanup(func() {
+		if t.Failed() {
+			return
+		}
+		if err := rec.Save(); err != nil {
+			t.Errorf("save cassette: %v", err)
+		}
+		if n := rec.Unused(); n > 0 {
+			t.Errorf("cassette %s: %d recorded interaction(s) not replayed", name, n)
+		}
+	})
+	return NewClaude(append(opts, rec.Option())...)
+}
+
 // TestHelloWorld shows a basic completion.
 func TestHelloWorld(t *testing.T) {
 	skipIfNoKey(t)
@@ -113,3 +156,128 @@ func TestWithTools(t *testing.T) {
 		fmt.Printf("tool: %s\ninput: %s\n", tu.Name, string(tu.Input))
 	}
 }
+
+// TestSamplingOptions checks that sampling and metadata options reach the
+// request body and headers.  The request is captured and aborted before it
+// leaves the process.
+func TestSamplingOptions(t *testing.T) {
+	errCaptured := errors.New("captured")
+	var body map[string]any
+	var header http.Header
+	capture := option.WithMiddleware(func(req *http.Request, _ option.MiddlewareNext) (*http.Response, error) {
+		header = req.Header.Clone()
+		data, _ := io.ReadAll(req.Body)
+		body = nil
+		json.Unmarshal(data, &body)
+		return nil, errCaptured
+	})
+	client := NewClaude(capture, option.WithAPIKey("test"), option.WithMaxRetries(0))
+
+	_, err := client.Complete(context.Background(), "hi",
+		WithTemperature(0),
+		WithTopP(0.9),
+		WithTopK(40),
+		WithStopSequences("END"),
+		WithUserID("user-123"),
+		WithServiceTier(anthropic.MessageNewParamsServiceTierStandardOnly),
+		WithBeta("feature-a"),
+		WithBeta("feature-b"),
+	)
+	if !errors.Is(err, errCaptured) {
+		t.Fatalf("got %v, want the capture error", err)
+	}
+
+	want := map[string]any{
+		"temperature":    0.0,
+		"top_p":          0.9,
+		"top_k":          40.0,
+		"stop_sequences": []any{"END"},
+		"metadata":       map[string]any{"user_id": "user-123"},
+		"service_tier":   "standard_only",
+	}
+	for k, v := range want {
+		if !reflect.DeepEqual(body[k], v) {
+			t.Errorf("%s = %#v, want %#v", k, body[k], v)
+		}
+	}
+	if got := header.Values("Anthropic-Beta"); !reflect.DeepEqual(got, []string{"feature-a", "feature-b"}) {
+		t.Errorf("anthropic-beta = %v", got)
+	}
+
+	// Unset options are omitted.
+	_, _, err = invokeClaude(context.Background(), client, nil, InitSession("sys", "hi"))
+	if !errors.Is(err, errCaptured) {
+		t.Fatalf("got %v, want the capture error", err)
+	}
+	for k := range want {
+		if _, ok := body[k]; ok {
+			t.Errorf("%s sent without its option", k)
+		}
+	}
+}
+
+// TestStopSequence stops a count at a custom stop sequence and checks that
+// the hit is reported by both Complete and InvokeClaude.
+func TestStopSequence(t *testing.T) {
+	client := cassetteClaude(t, "stop_sequence")
+	ctx := context.Background()
+	prompt := "Count from 1 to 10, one number per line, with no other text."
+
+	var stops []StopInfo
+	opts := []Option{
+		WithTemperature(0),
+		WithStopSequences("5"),
+		WithMaxTokens(100),
+		WithStopListener(func(s StopInfo) { stops = append(stops, s) }),
+	}
+
+	msg, err := client.Complete(ctx, prompt, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq, ok := StopSequenceHit(msg); !ok || seq != "5" {
+		t.Errorf("StopSequenceHit = %q, %v; stop_reason %s", seq, ok, msg.StopReason)
+	}
+	if strings.Contains(TextContent(msg), "6") {
+		t.Errorf("generation continued past the stop sequence: %q", TextContent(msg))
+	}
+
+	_, _, err = invokeClaude(ctx, client, nil, InitSession("You are a helpful assistant.", prompt), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StopInfo{{"stop_sequence", "5"}, {"stop_sequence", "5"}}
+	if !reflect.DeepEqual(stops, want) {
+		t.Errorf("stops = %v, want %v", stops, want)
+	}
+}
+
+// TestChat holds a two-turn conversation through Chat, adding each reply to
+// the session, and checks that the second answer uses the first turn.
+func TestChat(t *testing.T) {
+	client := cassetteClaude(t, "chat")
+	ctx := context.Background()
+
+	session := Session{}
+	session.Add(UserMessage{"My name is Ada. Reply with one short sentence."})
+	msgs, usage, err := client.Chat(ctx, session, WithSystem("You are a concise assistant."), WithTemperature(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.InputTokens == 0 || usage.OutputTokens == 0 {
+		t.Errorf("usage not populated: %+v", usage)
+	}
+	session.Add(msgs...)
+
+	session.Add(UserMessage{"What is my name? Answer with just the name."})
+	msgs, _, err = client.Chat(ctx, session, WithSystem("You are a concise assistant."), WithTemperature(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Add(msgs...)
+
+	answer, ok := session.FinalAnswer()
+	if !ok || !strings.Contains(answer, "Ada") {
+		t.Errorf("final answer %q does not mention Ada", answer)
+	}
+}
@@ -0,0 +1,246 @@
+package agentloop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// messagesEqual reports whether two messages are structurally identical.
+// Messages are compared by their JSON encoding so that whitespace
+// differences in tool inputs are ignored.
+func messagesEqual(a, b Message) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+// summarizeMessage returns a one-line description of a message for diffs.
+func summarizeMessage(m Message) string {
+	const maxLen = 80
+	text := []rune(strings.Join(strings.Fields(messageText(m)), " "))
+	if len(text) > maxLen {
+		text = append(text[:maxLen], '…')
+	}
+	return fmt.Sprintf("%s: %s", m.messageKind(), string(text))
+}
+
+// ToolOutputChange records a tool called with the same name and input in
+// both sessions that returned different output.
+type ToolOutputChange struct {
+	Name    string
+	Input   json.RawMessage
+	OutputA string
+	OutputB string
+}
+
+// SessionDiff is the structural difference between two sessions.
+type SessionDiff struct {
+	// CommonPrefix is the number of leading messages identical in both.
+	CommonPrefix int
+	// OnlyA and OnlyB hold each session's messages after the common prefix.
+	OnlyA []Message
+	OnlyB []Message
+	// ToolOutputChanges lists matching tool calls whose results differ,
+	// whether or not they fall inside the common prefix.
+	ToolOutputChanges []ToolOutputChange
+}
+
+// Identical reports whether the two sessions had no differences.
+func (d SessionDiff) Identical() bool {
+	return len(d.OnlyA) == 0 && len(d.OnlyB) == 0 && len(d.ToolOutputChanges) == 0
+}
+
+// String renders the diff in a unified-diff-like form: "-" lines come from
+// session A and "+" lines from session B, numbered by session position.
+func (d SessionDiff) String() string {
+	if d.Identical() {
+		return fmt.Sprintf("sessions identical (%d messages)\n", d.CommonPrefix)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "common prefix: %d messages\n", d.CommonPrefix)
+	if len(d.OnlyA) > 0 || len(d.OnlyB) > 0 {
+		fmt.Fprintf(&sb, "@@ diverged at message %d @@\n", d.CommonPrefix)
+		for i, m := range d.OnlyA {
+			fmt.Fprintf(&sb, "- [%d] %s\n", d.CommonPrefix+i, summarizeMessage(m))
+		}
+		for i, m := range d.OnlyB {
+			fmt.Fprintf(&sb, "+ [%d] %s\n", d.CommonPrefix+i, summarizeMessage(m))
+		}
+	}
+	if len(d.ToolOutputChanges) > 0 {
+		sb.WriteString("changed tool outputs:\n")
+		for _, c := range d.ToolOutputChanges {
+			fmt.Fprintf(&sb, "  %s %s\n", c.Name, c.Input)
+			fmt.Fprintf(&sb, "  - %s\n", summarizeMessage(ToolResultMessage{Output: c.OutputA}))
+			fmt.Fprintf(&sb, "  + %s\n", summarizeMessage(ToolResultMessage{Output: c.OutputB}))
+		}
+	}
+	return sb.String()
+}
+
+// idPairing pairs the tool call IDs of two sessions as they are walked in
+// step, so messages that differ only in their IDs compare equal.
+type idPairing struct {
+	aToB, bToA map[string]string
+}
+
+// pair records that id a in one session stands for id b in the other,
+// reporting false if either is already paired with a different ID.
+func (p *idPairing) pair(a, b string) bool {
+	if p.aToB == nil {
+		p.aToB, p.bToA = map[string]string{}, map[string]string{}
+	}
+	if got, ok := p.aToB[a]; ok {
+		return got == b
+	}
+	if got, ok := p.bToA[b]; ok {
+		return got == a
+	}
+	p.aToB[a], p.bToA[b] = b, a
+	return true
+}
+
+// equal reports whether a and b are identical apart from tool call IDs,
+// pairing the IDs of matching tool calls and results.
+func (p *idPairing) equal(a, b Message) bool {
+	switch ma := a.(type) {
+	case ToolCallMessage:
+		mb, ok := b.(ToolCallMessage)
+		return ok && toolCallKey(ma.Name, ma.Input) == toolCallKey(mb.Name, mb.Input) && p.pair(ma.ID, mb.ID)
+	case ToolResultMessage:
+		mb, ok := b.(ToolResultMessage)
+		return ok && ma.Output == mb.Output && p.pair(ma.ID, mb.ID)
+	default:
+		return messagesEqual(a, b)
+	}
+}
+
+// DiffSessions computes the structural difference between a and b.
+//
+// Tool call IDs are assigned per run, so they are ignored: a call or result
+// matches its counterpart if everything else is equal and its ID is used
+// consistently, i.e. each ID in a corresponds to a single ID in b.  Changed
+// tool outputs are likewise found by matching calls on name and input; the
+// k-th identical call in a is paired with the k-th identical call in b.
+func DiffSessions(a, b Session) SessionDiff {
+	var d SessionDiff
+	var ids idPairing
+	for d.CommonPrefix < len(a.Messages) && d.CommonPrefix < len(b.Messages) &&
+		ids.equal(a.Messages[d.CommonPrefix], b.Messages[d.CommonPrefix]) {
+		d.CommonPrefix++
+	}
+	d.OnlyA = a.Messages[d.CommonPrefix:]
+	d.OnlyB = b.Messages[d.CommonPrefix:]
+
+	callKey := func(tc ToolCallMessage) string { return toolCallKey(tc.Name, tc.Input) }
+	answeredB := make(map[string][]ToolExchange)
+	for _, ex := range b.ToolExchanges() {
+		if ex.Result != nil {
+			answeredB[callKey(ex.Call)] = append(answeredB[callKey(ex.Call)], ex)
+		}
+	}
+	for _, ex := range a.ToolExchanges() {
+		if ex.Result == nil {
+			continue
+		}
+		key := callKey(ex.Call)
+		if len(answeredB[key]) == 0 {
+			continue
+		}
+		other := answeredB[key][0]
+		answeredB[key] = answeredB[key][1:]
+		if ex.Result.Output != other.Result.Output {
+			d.ToolOutputChanges = append(d.ToolOutputChanges, ToolOutputChange{
+				Name:    ex.Call.Name,
+				Input:   ex.Call.Input,
+				OutputA: ex.Result.Output,
+				OutputB: other.Result.Output,
+			})
+		}
+	}
+	return d
+}
+
+// MergeConflict describes a position where both sides of a merge changed
+// the session differently.
+type MergeConflict struct {
+	Index  int
+	Base   Message // nil when the conflict is in messages appended after base
+	Ours   Message
+	Theirs Message
+}
+
+// MergeError is returned by MergeSessions when conflicts were found.
+type MergeError struct {
+	Conflicts []MergeConflict
+}
+
+func (e *MergeError) Error() string {
+	parts := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		parts[i] = fmt.Sprintf("message %d", c.Index)
+	}
+	return "merge conflict at " + strings.Join(parts, ", ")
+}
+
+// MergeSessions performs a three-way merge of two sessions forked from base.
+// Both ours and theirs must be at least as long as base.
+//
+// Within the base range each position is merged independently: a change on
+// one side only is taken, identical changes are taken once, and differing
+// changes conflict.  After the base range, messages appended by only one
+// side are taken; if both appended, one tail must extend the other,
+// otherwise the first differing position conflicts and the rest of ours is
+// kept.
+//
+// On conflict the returned session takes ours at every conflicting position
+// and a *MergeError describes each conflict.  The merged session keeps the
+// State of ours.
+func MergeSessions(base, ours, theirs Session) (Session, error) {
+	n := len(base.Messages)
+	if len(ours.Messages) < n || len(theirs.Messages) < n {
+		return Session{}, fmt.Errorf("merge: sessions must extend their common ancestor (base %d, ours %d, theirs %d messages)",
+			n, len(ours.Messages), len(theirs.Messages))
+	}
+
+	merged := Session{State: ours.State}
+	var conflicts []MergeConflict
+	for i := range n {
+		b, o, t := base.Messages[i], ours.Messages[i], theirs.Messages[i]
+		switch {
+		case messagesEqual(o, t), messagesEqual(t, b):
+			merged.addAt(ours.Time(i), o)
+		case messagesEqual(o, b):
+			merged.addAt(theirs.Time(i), t)
+		default:
+			merged.addAt(ours.Time(i), o)
+			conflicts = append(conflicts, MergeConflict{Index: i, Base: b, Ours: o, Theirs: t})
+		}
+	}
+
+	ot, tt := ours.Messages[n:], theirs.Messages[n:]
+	longer := ours
+	if len(tt) > len(ot) {
+		longer = theirs
+	}
+	for k := range min(len(ot), len(tt)) {
+		if !messagesEqual(ot[k], tt[k]) {
+			longer = ours
+			conflicts = append(conflicts, MergeConflict{Index: n + k, Ours: ot[k], Theirs: tt[k]})
+			break
+		}
+	}
+	for i := n; i < len(longer.Messages); i++ {
+		merged.addAt(longer.Time(i), longer.Messages[i])
+	}
+
+	if len(conflicts) > 0 {
+		return merged, &MergeError{Conflicts: conflicts}
+	}
+	return merged, nil
+}
@@ -0,0 +1,152 @@
+package agentloop
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDiffSessions(t *testing.T) {
+	a := InitSession("sys", "What is 1 + 2?")
+	a.Add(
+		ToolCallMessage{ID: "a1", Name: "add", Input: json.RawMessage(`{"a":1,"b":2}`)},
+		ToolResultMessage{ID: "a1", Output: "3"},
+		AssistantMessage{"It is 3."},
+	)
+	b := InitSession("sys", "What is 1 + 2?")
+	b.Add(
+		ToolCallMessage{ID: "b1", Name: "add", Input: json.RawMessage(`{ "a": 1, "b": 2 }`)},
+		ToolResultMessage{ID: "b1", Output: "4"},
+		AssistantMessage{"It is 4."},
+	)
+
+	d := DiffSessions(a, b)
+	// The calls match despite their IDs; the runs diverge at the result.
+	if d.CommonPrefix != 3 {
+		t.Errorf("CommonPrefix = %d, want 3", d.CommonPrefix)
+	}
+	if len(d.OnlyA) != 2 || len(d.OnlyB) != 2 {
+		t.Errorf("divergent lengths: %d, %d", len(d.OnlyA), len(d.OnlyB))
+	}
+	if len(d.ToolOutputChanges) != 1 {
+		t.Fatalf("got %d tool output changes, want 1", len(d.ToolOutputChanges))
+	}
+	c := d.ToolOutputChanges[0]
+	if c.Name != "add" || c.OutputA != "3" || c.OutputB != "4" {
+		t.Errorf("change: %+v", c)
+	}
+
+	out := d.String()
+	t.Log(out)
+	for _, want := range []string{
+		"common prefix: 3 messages",
+		"@@ diverged at message 3 @@",
+		"- [4] assistant: It is 3.",
+		"+ [4] assistant: It is 4.",
+		"changed tool outputs:",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("rendering missing %q", want)
+		}
+	}
+
+	if same := DiffSessions(a, a); !same.Identical() || same.CommonPrefix != 5 {
+		t.Errorf("self diff: %+v", same)
+	}
+}
+
+// TestDiffSessionsIgnoresIDs diffs two runs of the same conversation that
+// differ only in their tool call IDs, then one whose IDs are reused
+// inconsistently.
+func TestDiffSessionsIgnoresIDs(t *testing.T) {
+	run := func(id1, id2 string) Session {
+		s := InitSession("sys", "Add twice.")
+		s.Add(
+			ToolCallMessage{ID: id1, Name: "add", Input: json.RawMessage(`{"a":1,"b":2}`)},
+			ToolCallMessage{ID: id2, Name: "add", Input: json.RawMessage(`{"a":1,"b":2}`)},
+			ToolResultMessage{ID: id1, Output: "3"},
+			ToolResultMessage{ID: id2, Output: "3"},
+			AssistantMessage{"3 and 3."},
+		)
+		return s
+	}
+
+	if d := DiffSessions(run("toolu_a1", "toolu_a2"), run("toolu_b1", "toolu_b2")); !d.Identical() || d.CommonPrefix != 7 {
+		t.Errorf("same run with new IDs: %+v", d)
+	}
+	// Results answering the calls in the opposite order are a real
+	// difference.
+	swapped := run("toolu_b1", "toolu_b2")
+	swapped.Messages[4], swapped.Messages[5] = swapped.Messages[5], swapped.Messages[4]
+	if d := DiffSessions(run("toolu_a1", "toolu_a2"), swapped); d.CommonPrefix != 4 {
+		t.Errorf("swapped results: CommonPrefix = %d, want 4", d.CommonPrefix)
+	}
+}
+
+func TestMergeSessions(t *testing.T) {
+	base := InitSession("sys", "hi")
+
+	t.Run("one side appended", func(t *testing.T) {
+		ours := InitSession("sys", "hi")
+		ours.Add(AssistantMessage{"hello"})
+		merged, err := MergeSessions(base, ours, base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(merged.Messages) != 3 {
+			t.Errorf("got %d messages, want 3", len(merged.Messages))
+		}
+	})
+
+	t.Run("tail extends other", func(t *testing.T) {
+		ours := InitSession("sys", "hi")
+		ours.Add(AssistantMessage{"hello"})
+		theirs := InitSession("sys", "hi")
+		theirs.Add(AssistantMessage{"hello"}, UserMessage{"more"})
+		merged, err := MergeSessions(base, ours, theirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !messagesEqual(merged.Messages[3], UserMessage{"more"}) {
+			t.Errorf("got %v", merged.Messages)
+		}
+	})
+
+	t.Run("independent edits", func(t *testing.T) {
+		ours := Session{Messages: []Message{SystemMessage{"new sys"}, UserMessage{"hi"}}}
+		theirs := Session{Messages: []Message{SystemMessage{"sys"}, UserMessage{"hello"}}}
+		merged, err := MergeSessions(base, ours, theirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !messagesEqual(merged.Messages[0], SystemMessage{"new sys"}) || !messagesEqual(merged.Messages[1], UserMessage{"hello"}) {
+			t.Errorf("got %v", merged.Messages)
+		}
+	})
+
+	t.Run("conflicts", func(t *testing.T) {
+		ours := Session{Messages: []Message{SystemMessage{"ours"}, UserMessage{"hi"}, AssistantMessage{"A"}}}
+		theirs := Session{Messages: []Message{SystemMessage{"theirs"}, UserMessage{"hi"}, AssistantMessage{"B"}}}
+		merged, err := MergeSessions(base, ours, theirs)
+		var merr *MergeError
+		if !errors.As(err, &merr) {
+			t.Fatalf("expected *MergeError, got %v", err)
+		}
+		if len(merr.Conflicts) != 2 || merr.Conflicts[0].Index != 0 || merr.Conflicts[1].Index != 2 {
+			t.Errorf("conflicts: %+v", merr.Conflicts)
+		}
+		if merr.Conflicts[1].Base != nil {
+			t.Error("tail conflict should have no base message")
+		}
+		if !messagesEqual(merged.Messages[2], AssistantMessage{"A"}) {
+			t.Errorf("conflicting position should keep ours, got %v", merged.Messages[2])
+		}
+	})
+
+	t.Run("shorter than base", func(t *testing.T) {
+		if _, err := MergeSessions(base, Session{}, base); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
@@ -0,0 +1,294 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// Delta compares a per-case metric between two runs.  Base and Candidate
+// are means over the cases both runs contain; Diff is their difference and
+// [Low, High] its 95% confidence interval from a paired t-test.  The
+// interval is unbounded when there are fewer than minPairs cases or every
+// case changed by the same amount, since neither says anything about the
+// spread of the difference.
+type Delta struct {
+	Base      float64
+	Candidate float64
+	Diff      float64
+	Low       float64
+	High      float64
+
+	significant bool
+}
+
+// Significant reports whether the difference is significant at 95%.  For
+// pass/fail metrics, whose values are all 0 or 1, this is McNemar's exact
+// test on the cases that changed; otherwise it is whether the confidence
+// interval excludes zero.
+func (d Delta) Significant() bool { return d.significant }
+
+// CaseDelta compares one case across two runs.  Score is the mean grader
+// score, or 1/0 for pass/fail when the case has no grades.
+type CaseDelta struct {
+	CaseID          string
+	BasePass        bool
+	CandidatePass   bool
+	BaseScore       float64
+	CandidateScore  float64
+	BaseOutput      string
+	CandidateOutput string
+}
+
+// Comparison is the result of Compare.
+type Comparison struct {
+	Cases        []CaseDelta // cases present in both runs, in baseline order
+	Regressions  []CaseDelta // passed in the baseline, failed in the candidate
+	Improvements []CaseDelta // failed in the baseline, passed in the candidate
+
+	OnlyBase      []string // case IDs missing from the candidate
+	OnlyCandidate []string // case IDs missing from the baseline
+
+	PassRate Delta
+	Score    Delta
+	Tokens   Delta // input plus output tokens per case
+	Cost     Delta
+	Latency  Delta // seconds per case
+}
+
+// caseScore is the mean grade score of a result, or its pass/fail as 1/0.
+func caseScore(r CaseResult) float64 {
+	if len(r.Grades) == 0 {
+		return boolScore(r.Pass)
+	}
+	var sum float64
+	for _, g := range r.Grades {
+		sum += g.Score
+	}
+	return sum / float64(len(r.Grades))
+}
+
+func boolScore(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Compare matches the cases of two reports by ID and compares them.
+func Compare(base, candidate *Report) *Comparison {
+	c := &Comparison{}
+	cand := make(map[string]CaseResult, len(candidate.Results))
+	for _, r := range candidate.Results {
+		cand[r.CaseID] = r
+	}
+	seen := make(map[string]bool, len(base.Results))
+
+	var pass, score, tokens, cost, latency [2][]float64
+	for _, b := range base.Results {
+		seen[b.CaseID] = true
+		n, ok := cand[b.CaseID]
+		if !ok {
+			c.OnlyBase = append(c.OnlyBase, b.CaseID)
+			continue
+		}
+		d := CaseDelta{
+			CaseID:          b.CaseID,
+			BasePass:        b.Pass,
+			CandidatePass:   n.Pass,
+			BaseScore:       caseScore(b),
+			CandidateScore:  caseScore(n),
+			BaseOutput:      b.Output,
+			CandidateOutput: n.Output,
+		}
+		c.Cases = append(c.Cases, d)
+		switch {
+		case d.BasePass && !d.CandidatePass:
+			c.Regressions = append(c.Regressions, d)
+		case !d.BasePass && d.CandidatePass:
+			c.Improvements = append(c.Improvements, d)
+		}
+
+		for i, r := range []CaseResult{b, n} {
+			pass[i] = append(pass[i], boolScore(r.Pass))
+			score[i] = append(score[i], caseScore(r))
+			tokens[i] = append(tokens[i], float64(r.Usage.InputTokens+r.Usage.OutputTokens))
+			cost[i] = append(cost[i], r.Cost)
+			latency[i] = append(latency[i], r.Duration.Seconds())
+		}
+	}
+	for _, r := range candidate.Results {
+		if !seen[r.CaseID] {
+			c.OnlyCandidate = append(c.OnlyCandidate, r.CaseID)
+		}
+	}
+
+	c.PassRate = pairedDelta(pass[0], pass[1])
+	c.Score = pairedDelta(score[0], score[1])
+	c.Tokens = pairedDelta(tokens[0], tokens[1])
+	c.Cost = pairedDelta(cost[0], cost[1])
+	c.Latency = pairedDelta(latency[0], latency[1])
+	return c
+}
+
+// SignificantRegression reports whether the candidate's pass rate or mean
+// score is lower than the baseline's with 95% confidence.  It is intended
+// as a merge gate for prompt and model changes.
+func (c *Comparison) SignificantRegression() bool {
+	return c.PassRate.Significant() && c.PassRate.Diff < 0 ||
+		c.Score.Significant() && c.Score.Diff < 0
+}
+
+// minPairs is the fewest cases for which Compare reports a significant
+// difference.  McNemar's test cannot reach 95% with fewer than six changed
+// cases either.
+const minPairs = 5
+
+// tCritical holds two-sided 95% critical values of Student's t for 1 to 30
+// degrees of freedom.
+var tCritical = [...]float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// pairedDelta computes the mean difference b-a with a 95% confidence
+// interval, and whether it is significant.
+func pairedDelta(a, b []float64) Delta {
+	n := len(a)
+	if n == 0 {
+		return Delta{}
+	}
+	var d Delta
+	diffs := make([]float64, n)
+	binary := true
+	for i := range a {
+		d.Base += a[i]
+		d.Candidate += b[i]
+		diffs[i] = b[i] - a[i]
+		binary = binary && isBinary(a[i]) && isBinary(b[i])
+	}
+	d.Base /= float64(n)
+	d.Candidate /= float64(n)
+	d.Diff = d.Candidate - d.Base
+
+	var se float64
+	if n >= 2 {
+		var ss float64
+		for _, x := range diffs {
+			ss += (x - d.Diff) * (x - d.Diff)
+		}
+		se = math.Sqrt(ss/float64(n-1)) / math.Sqrt(float64(n))
+	}
+	d.Low, d.High = math.Inf(-1), math.Inf(1)
+	if n >= minPairs && se > 0 {
+		t := 1.96
+		if n-1 <= len(tCritical) {
+			t = tCritical[n-2]
+		}
+		d.Low, d.High = d.Diff-t*se, d.Diff+t*se
+	}
+	switch {
+	case n < minPairs:
+	case binary:
+		d.significant = mcNemar(diffs) < 0.05
+	default:
+		d.significant = d.Low > 0 || d.High < 0
+	}
+	return d
+}
+
+func isBinary(x float64) bool { return x == 0 || x == 1 }
+
+// mcNemar returns the two-sided p-value of McNemar's exact test for paired
+// pass/fail differences of -1, 0 or +1: the binomial probability of a split
+// between losses and gains at least as uneven as the observed one.
+func mcNemar(diffs []float64) float64 {
+	var loss, gain int
+	for _, x := range diffs {
+		switch {
+		case x < 0:
+			loss++
+		case x > 0:
+			gain++
+		}
+	}
+	n, k := loss+gain, min(loss, gain)
+	if n == 0 {
+		return 1
+	}
+	// P(X <= k) for X ~ Binomial(n, 1/2), in logs so large runs don't
+	// overflow.
+	var p float64
+	for i := 0; i <= k; i++ {
+		p += math.Exp(lchoose(n, i) - float64(n)*math.Ln2)
+	}
+	return min(1, 2*p)
+}
+
+// lchoose returns the natural log of the binomial coefficient n choose k.
+func lchoose(n, k int) float64 {
+	a, _ := math.Lgamma(float64(n + 1))
+	b, _ := math.Lgamma(float64(k + 1))
+	c, _ := math.Lgamma(float64(n - k + 1))
+	return a - b - c
+}
+
+// WriteMarkdown writes the aggregate deltas followed by the regressed and
+// improved cases.
+func (c *Comparison) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# Evaluation comparison\n\n")
+	fmt.Fprintf(&b, "%d cases compared: %d regressions, %d improvements.\n",
+		len(c.Cases), len(c.Regressions), len(c.Improvements))
+	if len(c.OnlyBase) > 0 || len(c.OnlyCandidate) > 0 {
+		fmt.Fprintf(&b, "%d cases only in the baseline, %d only in the candidate.\n",
+			len(c.OnlyBase), len(c.OnlyCandidate))
+	}
+	if c.SignificantRegression() {
+		b.WriteString("\n**Significant regression.**\n")
+	}
+
+	b.WriteString("\n| Metric | Baseline | Candidate | Δ | 95% CI |\n|---|---|---|---|---|\n")
+	row := func(name string, d Delta, format func(float64) string) {
+		mark := ""
+		if d.Significant() {
+			mark = " *"
+		}
+		sign := ""
+		if d.Diff >= 0 {
+			sign = "+"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s%s%s | [%s, %s] |\n", name,
+			format(d.Base), format(d.Candidate), sign, format(d.Diff), mark, format(d.Low), format(d.High))
+	}
+	percent := func(x float64) string { return fmt.Sprintf("%.1f%%", 100*x) }
+	row("Pass rate", c.PassRate, percent)
+	row("Score", c.Score, func(x float64) string { return fmt.Sprintf("%.3f", x) })
+	row("Tokens", c.Tokens, func(x float64) string { return fmt.Sprintf("%.0f", x) })
+	row("Cost", c.Cost, func(x float64) string { return fmt.Sprintf("$%.4f", x) })
+	row("Latency", c.Latency, func(x float64) string {
+		if math.IsInf(x, 0) {
+			return fmt.Sprint(x)
+		}
+		return (time.Duration(x * float64(time.Second))).Round(time.Millisecond).String()
+	})
+	b.WriteString("\n\\* significant at 95%\n")
+
+	list := func(title string, ds []CaseDelta) {
+		if len(ds) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n| Case | Baseline output | Candidate output |\n|---|---|---|\n", title)
+		for _, d := range ds {
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", mdCell(d.CaseID), mdCell(d.BaseOutput), mdCell(d.CandidateOutput))
+		}
+	}
+	list("Regressions", c.Regressions)
+	list("Improvements", c.Improvements)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
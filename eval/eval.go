@@ -0,0 +1,202 @@
+// Package eval runs agents over datasets of inputs, grades their answers and
+// reports pass rates, token usage, cost and latency.
+//
+//	report, err := eval.Run(ctx, agent, cases, []eval.Grader{
+//		eval.ExactMatch(),
+//		eval.ToolCalled("search"),
+//	}, eval.WithConcurrency(8))
+//	report.WriteMarkdown(os.Stdout)
+package eval
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	agentloop "github.com/tdb-alcorn/agent-loop-go"
+)
+
+// Agent is the system under evaluation.
+type Agent struct {
+	Model        agentloop.InvokeModelFunc
+	Tools        []agentloop.Tool
+	SystemPrompt string
+	Options      []agentloop.AgentLoopOption // passed to every AgentLoop call
+}
+
+// Case is one dataset entry.
+type Case struct {
+	ID       string         `json:"id"`
+	Input    string         `json:"input"`
+	Expected string         `json:"expected,omitempty"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// ReadCases decodes a dataset from JSON Lines, one Case per line.  Blank
+// lines are skipped.
+func ReadCases(r io.Reader) ([]Case, error) {
+	var cases []Case
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var c Case
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		cases = append(cases, c)
+	}
+	return cases, scanner.Err()
+}
+
+// CostFunc converts token usage to a monetary cost.
+type CostFunc func(agentloop.Usage) float64
+
+// Option configures Run.
+type Option func(*config)
+
+type config struct {
+	concurrency int
+	costFunc    CostFunc
+	progress    func(CaseResult)
+}
+
+// WithConcurrency sets the maximum number of cases run at once (default 4).
+func WithConcurrency(n int) Option {
+	return func(c *config) { c.concurrency = max(1, n) }
+}
+
+// WithCostFunc sets the function used to price each case's usage, e.g. the
+// Cost method of an agentloop.Pricing.  Without it, costs are reported as
+// zero.
+func WithCostFunc(fn CostFunc) Option {
+	return func(c *config) { c.costFunc = fn }
+}
+
+// WithProgress sets a function called as each case finishes.  Calls are
+// serialised but arrive in completion order, not dataset order.
+func WithProgress(fn func(CaseResult)) Option {
+	return func(c *config) { c.progress = fn }
+}
+
+// CaseResult is the outcome of running and grading one case.
+type CaseResult struct {
+	CaseID   string          `json:"case_id"`
+	Input    string          `json:"input"`
+	Output   string          `json:"output"`
+	Pass     bool            `json:"pass"`
+	Grades   []GradeResult   `json:"grades"`
+	Usage    agentloop.Usage `json:"usage"`
+	Cost     float64         `json:"cost"`
+	Duration time.Duration   `json:"duration_ns"`
+	Error    string          `json:"error,omitempty"`
+
+	// Session is the agent's transcript.  It is not written to reports.
+	Session agentloop.Session `json:"-"`
+}
+
+// Run evaluates agent on every case with at most the configured number of
+// cases in flight, grading each finished transcript with every grader.  A
+// case passes when the agent ran without error and every grader passed.
+// Results are returned in dataset order.  Run returns an error only if ctx
+// is cancelled; per-case failures are recorded in the report.
+func Run(ctx context.Context, agent Agent, cases []Case, graders []Grader, opts ...Option) (*Report, error) {
+	cfg := &config{concurrency: 4}
+	for _, o := range opts {
+		o(cfg)
+	}
+
+	// Cases not reached before cancellation keep this placeholder result.
+	cases = append([]Case(nil), cases...)
+	results := make([]CaseResult, len(cases))
+	for i, c := range cases {
+		if c.ID == "" {
+			cases[i].ID = strconv.Itoa(i)
+		}
+		results[i] = CaseResult{CaseID: cases[i].ID, Input: c.Input, Error: "not run"}
+	}
+
+	sem := make(chan struct{}, cfg.concurrency)
+	var wg sync.WaitGroup
+	var progressMu sync.Mutex
+
+dispatch:
+	for i, c := range cases {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break dispatch
+		}
+		wg.Add(1)
+		go func(i int, c Case) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = runCase(ctx, agent, c, graders, cfg)
+			if cfg.progress != nil {
+				progressMu.Lock()
+				cfg.progress(results[i])
+				progressMu.Unlock()
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return &Report{Results: results}, err
+	}
+	return &Report{Results: results}, nil
+}
+
+// runCase runs the agent on a single case and grades the result.
+func runCase(ctx context.Context, agent Agent, c Case, graders []Grader, cfg *config) CaseResult {
+	res := CaseResult{CaseID: c.ID, Input: c.Input}
+
+	var usage agentloop.Usage
+	model := func(ctx context.Context, tools []agentloop.ToolDefinition, s agentloop.Session) ([]agentloop.Message, agentloop.Usage, error) {
+		msgs, u, err := agent.Model(ctx, tools, s)
+		usage = usage.Add(u)
+		return msgs, u, err
+	}
+
+	session := agentloop.Session{}
+	if agent.SystemPrompt != "" {
+		session.Add(agentloop.SystemMessage{Content: agent.SystemPrompt})
+	}
+	session.Add(agentloop.UserMessage{Content: c.Input})
+
+	start := time.Now()
+	session, err := agentloop.AgentLoop(ctx, model, agent.Tools, session, agent.Options...)
+	res.Duration = time.Since(start)
+	res.Session = session
+	res.Output, _ = session.FinalAnswer()
+	res.Usage = usage
+	if cfg.costFunc != nil {
+		res.Cost = cfg.costFunc(usage)
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	res.Pass = err == nil
+	for _, g := range graders {
+		gr := GradeResult{Grader: g.Name}
+		grade, gerr := g.Grade(ctx, c, session)
+		if gerr != nil {
+			grade = Grade{Reason: "Error: " + gerr.Error()}
+		}
+		gr.Grade = grade
+		res.Grades = append(res.Grades, gr)
+		res.Pass = res.Pass && grade.Pass
+	}
+	return res
+}
@@ -0,0 +1,311 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	agentloop "github.com/tdb-alcorn/agent-loop-go"
+	"github.com/tdb-alcorn/agent-loop-go/agentlooptest"
+)
+
+// upperAgent answers every case with its input upper-cased, calling the
+// "shout" tool first when the input ends with "!".
+func upperAgent(inFlight, peak *atomic.Int32) Agent {
+	model := func(ctx context.Context, _ []agentloop.ToolDefinition, s agentloop.Session) ([]agentloop.Message, agentloop.Usage, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		usage := agentloop.Usage{InputTokens: 10, OutputTokens: 2}
+		last := s.Messages[len(s.Messages)-1]
+		if u, ok := last.(agentloop.UserMessage); ok {
+			if strings.HasSuffix(u.Content, "!") {
+				return []agentloop.Message{agentlooptest.ToolCall("c1", "shout", map[string]string{"text": u.Content})}, usage, nil
+			}
+			return []agentloop.Message{agentloop.AssistantMessage{Content: strings.ToUpper(u.Content)}}, usage, nil
+		}
+		return []agentloop.Message{agentloop.AssistantMessage{Content: last.(agentloop.ToolResultMessage).Output}}, usage, nil
+	}
+	shout := agentloop.Tool{
+		Definition: agentloop.ToolDefinition{Name: "shout", InputSchema: agentloop.ToolInputSchema{Type: "object"}},
+		Handler: func(_ context.Context, input json.RawMessage) (string, error) {
+			var args struct{ Text string }
+			json.Unmarshal(input, &args)
+			return strings.ToUpper(args.Text), nil
+		},
+	}
+	return Agent{Model: model, Tools: []agentloop.Tool{shout}, SystemPrompt: "Shout."}
+}
+
+func TestRun(t *testing.T) {
+	cases, err := ReadCases(strings.NewReader(`{"id":"a","input":"hi","expected":"HI"}
+
+{"id":"b","input":"go!","expected":"GO!"}
+{"input":"no","expected":"yes"}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		cases = append(cases, Case{ID: "x" + string(rune('0'+i)), Input: "x", Expected: "X"})
+	}
+
+	var inFlight, peak atomic.Int32
+	var progress int
+	report, err := Run(context.Background(), upperAgent(&inFlight, &peak), cases,
+		[]Grader{ExactMatch(), ToolCalled("shout")},
+		WithConcurrency(3),
+		WithCostFunc(func(u agentloop.Usage) float64 { return float64(u.InputTokens) / 1000 }),
+		WithProgress(func(CaseResult) { progress++ }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if peak.Load() > 3 {
+		t.Errorf("peak concurrency %d, want <= 3", peak.Load())
+	}
+	if progress != len(cases) {
+		t.Errorf("progress called %d times, want %d", progress, len(cases))
+	}
+	if got := report.Results[2].CaseID; got != "2" {
+		t.Errorf("missing ID defaulted to %q, want \"2\"", got)
+	}
+	if cases[2].ID != "" {
+		t.Error("Run modified the caller's cases")
+	}
+
+	b := report.Results[1]
+	if b.CaseID != "b" || b.Output != "GO!" || !b.Pass || b.Usage.InputTokens != 20 || b.Cost != 0.02 {
+		t.Errorf("case b: %+v", b)
+	}
+	a := report.Results[0]
+	if a.Pass || !a.Grades[0].Pass || a.Grades[1].Pass {
+		t.Errorf("case a should pass exact_match and fail tool_called: %+v", a.Grades)
+	}
+
+	s := report.Summary()
+	if s.Cases != 8 || s.Passed != 1 || s.Errors != 0 {
+		t.Errorf("summary: %+v", s)
+	}
+	if s.GraderPass["exact_match"] != 7.0/8 || s.Usage.InputTokens != 90 {
+		t.Errorf("summary: %+v", s)
+	}
+}
+
+func TestRunAgentError(t *testing.T) {
+	m := agentlooptest.NewModel()
+	m.Fail(context.DeadlineExceeded)
+	report, err := Run(context.Background(), Agent{Model: m.Invoke}, []Case{{ID: "a", Input: "hi"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := report.Results[0]; r.Pass || r.Error == "" {
+		t.Errorf("agent failure should fail the case: %+v", r)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err := Run(ctx, Agent{}, []Case{{ID: "a"}, {ID: "b"}}, nil)
+	if err == nil {
+		t.Fatal("expected context error")
+	}
+	if len(report.Results) != 2 || report.Results[1].Error != "not run" {
+		t.Errorf("results: %+v", report.Results)
+	}
+}
+
+func session(answer string) agentloop.Session {
+	s := agentloop.InitSession("sys", "q")
+	s.Add(agentloop.AssistantMessage{Content: answer})
+	return s
+}
+
+func TestGraders(t *testing.T) {
+	ctx := context.Background()
+	c := Case{Input: "q", Expected: "42"}
+	tests := []struct {
+		grader Grader
+		answer string
+		pass   bool
+	}{
+		{ExactMatch(), " 42\n", true},
+		{ExactMatch(), "41", false},
+		{Regex(`^\d+$`), "42", true},
+		{Regex(`^\d+$`), "forty-two", false},
+		{JSONField("answer.value", 42), "Here you go:\n```json\n{\"answer\": {\"value\": 42.0}}\n```", true},
+		{JSONField("items.1", "b"), `Result: {"items": ["a", "b"]} done`, true},
+		{JSONField("answer", 42), `{"answer": "42"}`, false},
+		{JSONField("missing", 1), `{}`, false},
+		{JSONField("x", 1), "not json", false},
+	}
+	for _, tt := range tests {
+		g, err := tt.grader.Grade(ctx, c, session(tt.answer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.Pass != tt.pass {
+			t.Errorf("%s on %q: pass = %v (%s), want %v", tt.grader.Name, tt.answer, g.Pass, g.Reason, tt.pass)
+		}
+	}
+}
+
+func TestJudge(t *testing.T) {
+	m := agentlooptest.NewModel()
+	m.Reply(agentlooptest.Text("PASS\nThe answer is correct.")).
+		Expect(agentlooptest.SystemContains("be polite"), agentlooptest.LastUserContains("Answer to judge:\nhello"))
+	m.Reply(agentlooptest.Text("**FAIL**\nRude."))
+	m.Reply(agentlooptest.Text("Maybe?"))
+
+	judge := Judge("polite", m.Invoke, "be polite")
+	want := []bool{true, false}
+	for _, pass := range want {
+		g, err := judge.Grade(context.Background(), Case{Input: "greet me"}, session("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.Pass != pass {
+			t.Errorf("pass = %v, want %v", g.Pass, pass)
+		}
+	}
+	if _, err := judge.Grade(context.Background(), Case{}, session("hello")); err == nil {
+		t.Error("expected error for a missing verdict")
+	}
+	m.AssertExhausted(t)
+}
+
+func TestReportRoundTrip(t *testing.T) {
+	report := &Report{Results: []CaseResult{
+		{CaseID: "a", Input: "hi", Output: "HI", Pass: true, Usage: agentloop.Usage{InputTokens: 5}, Cost: 0.5, Duration: time.Second,
+			Grades: []GradeResult{{Grader: "exact_match", Grade: Grade{Pass: true, Score: 1}}}},
+		{CaseID: "b", Input: "x|y", Output: "nope", Error: "boom",
+			Grades: []GradeResult{{Grader: "exact_match", Grade: Grade{Reason: "got \"nope\""}}}},
+	}}
+	var buf bytes.Buffer
+	if err := report.WriteJSONL(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadReport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Results) != 2 || got.Results[0].Duration != time.Second || got.Results[1].Grades[0].Reason != `got "nope"` {
+		t.Errorf("round trip: %+v", got.Results)
+	}
+
+	buf.Reset()
+	if err := got.WriteMarkdown(&buf); err != nil {
+		t.Fatal(err)
+	}
+	md := buf.String()
+	for _, want := range []string{"| Passed | 1 (50.0%) |", "| exact_match | 50.0% |", "| Cost | $0.5000 |", "error: boom; exact_match: got \"nope\""} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown missing %q:\n%s", want, md)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	result := func(id string, pass bool, tokens int64, d time.Duration) CaseResult {
+		return CaseResult{CaseID: id, Pass: pass, Output: id + " out", Usage: agentloop.Usage{InputTokens: tokens}, Duration: d}
+	}
+	base := &Report{}
+	cand := &Report{}
+	for i := 0; i < 10; i++ {
+		id := strconv.Itoa(i)
+		base.Results = append(base.Results, result(id, true, 100, time.Second))
+		cand.Results = append(cand.Results, result(id, i >= 6, 80+int64(i), 2*time.Second))
+	}
+	base.Results = append(base.Results, result("gone", true, 1, 0))
+	cand.Results = append(cand.Results, result("new", true, 1, 0))
+
+	path := filepath.Join(t.TempDir(), "cand.jsonl")
+	var buf bytes.Buffer
+	cand.WriteJSONL(&buf)
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cand, err := LoadReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Compare(base, cand)
+	if len(c.Cases) != 10 || len(c.Regressions) != 6 || len(c.Improvements) != 0 {
+		t.Errorf("cases %d, regressions %d, improvements %d", len(c.Cases), len(c.Regressions), len(c.Improvements))
+	}
+	if len(c.OnlyBase) != 1 || c.OnlyBase[0] != "gone" || len(c.OnlyCandidate) != 1 || c.OnlyCandidate[0] != "new" {
+		t.Errorf("unmatched: %v %v", c.OnlyBase, c.OnlyCandidate)
+	}
+	if c.PassRate.Diff != -0.6 || !c.PassRate.Significant() || !c.SignificantRegression() {
+		t.Errorf("pass rate: %+v", c.PassRate)
+	}
+	// Every case slowed by exactly a second: the spread is unknown, so the
+	// interval is unbounded rather than the point [1, 1].
+	if c.Latency.Diff != 1 || !math.IsInf(c.Latency.Low, -1) || c.Latency.Significant() || c.Tokens.Diff != -15.5 || !c.Tokens.Significant() {
+		t.Errorf("latency %+v, tokens %+v", c.Latency, c.Tokens)
+	}
+
+	buf.Reset()
+	c.WriteMarkdown(&buf)
+	for _, want := range []string{"6 regressions", "**Significant regression.**", "| Pass rate | 100.0% | 40.0% | -60.0% * |", "| 0 | 0 out | 0 out |"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("markdown missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	// A run compared with itself has no significant change.
+	if same := Compare(base, base); same.SignificantRegression() || same.Score.Significant() {
+		t.Errorf("self comparison: %+v", same.Score)
+	}
+}
+
+func TestCompareSignificance(t *testing.T) {
+	runs := func(n int, flip func(i int) (base, cand bool)) (*Report, *Report) {
+		base, cand := &Report{}, &Report{}
+		for i := 0; i < n; i++ {
+			b, c := flip(i)
+			base.Results = append(base.Results, CaseResult{CaseID: strconv.Itoa(i), Pass: b})
+			cand.Results = append(cand.Results, CaseResult{CaseID: strconv.Itoa(i), Pass: c})
+		}
+		return base, cand
+	}
+	for _, tc := range []struct {
+		name        string
+		n           int
+		flip        func(i int) (bool, bool)
+		significant bool
+	}{
+		{"two cases both fixed", 2, func(int) (bool, bool) { return false, true }, false},
+		{"five of five fixed", 5, func(int) (bool, bool) { return false, true }, false},
+		{"six of six fixed", 6, func(int) (bool, bool) { return false, true }, true},
+		{"five of forty regressed", 40, func(i int) (bool, bool) { return true, i >= 5 }, false},
+		{"eight of forty regressed", 40, func(i int) (bool, bool) { return true, i >= 8 }, true},
+		{"six regressed, one fixed", 40, func(i int) (bool, bool) { return i > 0, i >= 7 || i == 0 }, false},
+	} {
+		base, cand := runs(tc.n, tc.flip)
+		c := Compare(base, cand)
+		if c.PassRate.Significant() != tc.significant {
+			t.Errorf("%s: significant = %v, want %v (%+v)", tc.name, !tc.significant, tc.significant, c.PassRate)
+		}
+		if c.Score.Significant() != c.PassRate.Significant() {
+			t.Errorf("%s: ungraded score significance differs from pass rate", tc.name)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	agentloop "github.com/tdb-alcorn/agent-loop-go"
+)
+
+// Grade is a grader's verdict on one case.
+type Grade struct {
+	Pass   bool    `json:"pass"`
+	Score  float64 `json:"score"` // 0..1; 1 for a pass and 0 for a fail unless the grader is finer-grained
+	Reason string  `json:"reason,omitempty"`
+}
+
+// GradeResult is a Grade attributed to the grader that produced it.
+type GradeResult struct {
+	Grader string `json:"grader"`
+	Grade
+}
+
+// GradeFunc grades the transcript an agent produced for a case.  Returning
+// an error records a failing grade with the error as the reason.
+type GradeFunc func(ctx context.Context, c Case, s agentloop.Session) (Grade, error)
+
+// Grader pairs a name, used in reports, with its grading function.
+type Grader struct {
+	Name  string
+	Grade GradeFunc
+}
+
+// passFail returns a binary Grade.
+func passFail(pass bool, reason string) Grade {
+	g := Grade{Pass: pass, Reason: reason}
+	if pass {
+		g.Score = 1
+	}
+	return g
+}
+
+// ExactMatch passes when the final answer equals the case's Expected value,
+// ignoring leading and trailing whitespace.
+func ExactMatch() Grader {
+	return Grader{
+		Name: "exact_match",
+		Grade: func(_ context.Context, c Case, s agentloop.Session) (Grade, error) {
+			answer, _ := s.FinalAnswer()
+			got, want := strings.TrimSpace(answer), strings.TrimSpace(c.Expected)
+			if got == want {
+				return passFail(true, ""), nil
+			}
+			return passFail(false, fmt.Sprintf("got %q, want %q", got, want)), nil
+		},
+	}
+}
+
+// Regex passes when the final answer matches pattern.  It panics if pattern
+// does not compile.
+func Regex(pattern string) Grader {
+	re := regexp.MustCompile(pattern)
+	return Grader{
+		Name: "regex:" + pattern,
+		Grade: func(_ context.Context, _ Case, s agentloop.Session) (Grade, error) {
+			answer, _ := s.FinalAnswer()
+			if re.MatchString(answer) {
+				return passFail(true, ""), nil
+			}
+			return passFail(false, "answer does not match "+pattern), nil
+		},
+	}
+}
+
+// jsonFence matches a fenced code block, optionally tagged json.
+var jsonFence = regexp.MustCompile("(?s)```(?:json)?\\s*\\n(.*?)```")
+
+// extractJSON decodes the first JSON value in text: the whole text, the
+// first fenced code block, or the span from the first '{' to the last '}'.
+func extractJSON(text string) (any, error) {
+	candidates := []string{strings.TrimSpace(text)}
+	if m := jsonFence.FindStringSubmatch(text); m != nil {
+		candidates = append(candidates, m[1])
+	}
+	if i, j := strings.Index(text, "{"), strings.LastIndex(text, "}"); i >= 0 && j > i {
+		candidates = append(candidates, text[i:j+1])
+	}
+	for _, c := range candidates {
+		dec := json.NewDecoder(strings.NewReader(c))
+		dec.UseNumber()
+		var v any
+		if dec.Decode(&v) == nil {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("no JSON value found in answer")
+}
+
+// lookupPath follows a dotted path such as "result.items.0.name" through
+// decoded JSON.  Numeric segments index arrays.
+func lookupPath(v any, path string) (any, bool) {
+	if path == "" {
+		return v, true
+	}
+	for _, seg := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			next, ok := node[seg]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []any:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// JSONField parses the final answer as JSON (accepting a fenced block or
+// surrounding prose) and passes when the value at the dotted path equals
+// want.  An empty path compares the whole document.
+func JSONField(path string, want any) Grader {
+	return Grader{
+		Name: "json_field:" + path,
+		Grade: func(_ context.Context, _ Case, s agentloop.Session) (Grade, error) {
+			answer, _ := s.FinalAnswer()
+			doc, err := extractJSON(answer)
+			if err != nil {
+				return passFail(false, err.Error()), nil
+			}
+			got, ok := lookupPath(doc, path)
+			if !ok {
+				return passFail(false, fmt.Sprintf("path %q not found", path)), nil
+			}
+			if !agentloop.JSONEqual(got, want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(want)
+				return passFail(false, fmt.Sprintf("%s = %s, want %s", path, gotJSON, wantJSON)), nil
+			}
+			return passFail(true, ""), nil
+		},
+	}
+}
+
+// ToolCalled passes when the agent called the named tool at least once.
+func ToolCalled(name string) Grader {
+	return Grader{
+		Name: "tool_called:" + name,
+		Grade: func(_ context.Context, _ Case, s agentloop.Session) (Grade, error) {
+			if n := s.ToolStats()[name].Calls; n > 0 {
+				return passFail(true, fmt.Sprintf("called %d time(s)", n)), nil
+			}
+			return passFail(false, "tool "+name+" was not called"), nil
+		},
+	}
+}
+
+// judgePrompt instructs an LLM judge.  %s is replaced by the criteria.
+const judgePrompt = `You are a strict evaluator of an AI assistant's answers.
+Judge the answer against these criteria:
+
+%s
+
+Reply with exactly PASS or FAIL on the first line, followed by a short explanation.`
+
+// Judge returns an LLM-as-judge grader.  A separate agent, driven by model
+// with no tools, is shown the case input, the expected answer (if any) and
+// the agent's final answer, and must reply PASS or FAIL on its first line.
+func Judge(name string, model agentloop.InvokeModelFunc, criteria string) Grader {
+	return Grader{
+		Name: name,
+		Grade: func(ctx context.Context, c Case, s agentloop.Session) (Grade, error) {
+			answer, _ := s.FinalAnswer()
+			var prompt strings.Builder
+			fmt.Fprintf(&prompt, "Input:\n%s\n\n", c.Input)
+			if c.Expected != "" {
+				fmt.Fprintf(&prompt, "Reference answer:\n%s\n\n", c.Expected)
+			}
+			fmt.Fprintf(&prompt, "Answer to judge:\n%s", answer)
+
+			judged, err := agentloop.AgentLoop(ctx, model, nil,
+				agentloop.InitSession(fmt.Sprintf(judgePrompt, criteria), prompt.String()))
+			if err != nil {
+				return Grade{}, err
+			}
+			verdict, _ := judged.FinalAnswer()
+			first, rest, _ := strings.Cut(strings.TrimSpace(verdict), "\n")
+			reason := strings.TrimSpace(rest)
+			switch strings.ToUpper(strings.Trim(strings.TrimSpace(first), "*.:")) {
+			case "PASS":
+				return passFail(true, reason), nil
+			case "FAIL":
+				return passFail(false, reason), nil
+			default:
+				return Grade{}, fmt.Errorf("judge gave no verdict: %q", verdict)
+			}
+		},
+	}
+}
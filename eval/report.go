@@ -0,0 +1,165 @@
+package eval
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	agentloop "github.com/tdb-alcorn/agent-loop-go"
+)
+
+// Report holds the results of one evaluation run in dataset order.
+type Report struct {
+	Results []CaseResult
+}
+
+// Summary aggregates a report.
+type Summary struct {
+	Cases        int
+	Passed       int
+	Errors       int // cases where the agent itself failed
+	PassRate     float64
+	GraderPass   map[string]float64 // grader name → pass rate
+	Usage        agentloop.Usage
+	Cost         float64
+	MeanDuration time.Duration
+}
+
+// Summary computes aggregate statistics over the report's results.
+func (r *Report) Summary() Summary {
+	s := Summary{Cases: len(r.Results), GraderPass: make(map[string]float64)}
+	graded := make(map[string]int)
+	var total time.Duration
+	for _, res := range r.Results {
+		if res.Pass {
+			s.Passed++
+		}
+		if res.Error != "" {
+			s.Errors++
+		}
+		for _, g := range res.Grades {
+			graded[g.Grader]++
+			if g.Pass {
+				s.GraderPass[g.Grader]++
+			}
+		}
+		s.Usage = s.Usage.Add(res.Usage)
+		s.Cost += res.Cost
+		total += res.Duration
+	}
+	if s.Cases > 0 {
+		s.PassRate = float64(s.Passed) / float64(s.Cases)
+		s.MeanDuration = total / time.Duration(s.Cases)
+	}
+	for name, n := range graded {
+		s.GraderPass[name] /= float64(n)
+	}
+	return s
+}
+
+// WriteJSONL writes one CaseResult per line.  Transcripts are not included.
+func (r *Report) WriteJSONL(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, res := range r.Results {
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadReport decodes a report written by WriteJSONL.
+func ReadReport(r io.Reader) (*Report, error) {
+	report := &Report{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var res CaseResult
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report, scanner.Err()
+}
+
+// LoadReport reads a report written by WriteJSONL from a file.
+func LoadReport(path string) (*Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	report, err := ReadReport(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return report, nil
+}
+
+// mdCell escapes text for a Markdown table cell.
+func mdCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > 60 {
+		s = string(r[:60]) + "…"
+	}
+	return s
+}
+
+// WriteMarkdown writes a summary table followed by one row per case.
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	s := r.Summary()
+	var b strings.Builder
+	b.WriteString("# Evaluation report\n\n")
+	b.WriteString("| Metric | Value |\n|---|---|\n")
+	fmt.Fprintf(&b, "| Cases | %d |\n", s.Cases)
+	fmt.Fprintf(&b, "| Passed | %d (%.1f%%) |\n", s.Passed, 100*s.PassRate)
+	fmt.Fprintf(&b, "| Errors | %d |\n", s.Errors)
+	names := make([]string, 0, len(s.GraderPass))
+	for name := range s.GraderPass {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "| %s | %.1f%% |\n", mdCell(name), 100*s.GraderPass[name])
+	}
+	fmt.Fprintf(&b, "| Tokens | %d in / %d out |\n", s.Usage.InputTokens, s.Usage.OutputTokens)
+	fmt.Fprintf(&b, "| Cost | $%.4f |\n", s.Cost)
+	fmt.Fprintf(&b, "| Mean latency | %s |\n", s.MeanDuration.Round(time.Millisecond))
+
+	b.WriteString("\n## Cases\n\n")
+	b.WriteString("| Case | Result | Output | Notes | Tokens | Latency |\n|---|---|---|---|---|---|\n")
+	for _, res := range r.Results {
+		result := "✅ pass"
+		if !res.Pass {
+			result = "❌ fail"
+		}
+		var notes []string
+		if res.Error != "" {
+			notes = append(notes, "error: "+res.Error)
+		}
+		for _, g := range res.Grades {
+			if !g.Pass {
+				note := g.Grader
+				if g.Reason != "" {
+					note += ": " + g.Reason
+				}
+				notes = append(notes, note)
+			}
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %d | %s |\n",
+			mdCell(res.CaseID), result, mdCell(res.Output), mdCell(strings.Join(notes, "; ")),
+			res.Usage.InputTokens+res.Usage.OutputTokens, res.Duration.Round(time.Millisecond))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -2,8 +2,9 @@ module github.com/tdb-alcorn/agent-loop-go
 
 go 1.24.6
 
+require github.com/anthropics/anthropic-sdk-go v1.26.0
+
 require (
-	github.com/anthropics/anthropic-sdk-go v1.26.0 // indirect
 	github.com/tidwall/gjson v1.18.0 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
@@ -0,0 +1,192 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// HandoffToolPrefix prefixes the name of every handoff tool: an agent hands
+// the conversation to the billing agent by calling transfer_to_billing.
+const HandoffToolPrefix = "transfer_to_"
+
+// DefaultMaxHandoffs limits the handoffs in one Team.Run call unless the
+// team sets MaxHandoffs.
+const DefaultMaxHandoffs = 10
+
+// ErrMaxHandoffs is returned by Team.Run when the agents keep handing the
+// conversation back and forth beyond the team's limit.
+var ErrMaxHandoffs = errors.New("maximum handoffs reached")
+
+// Agent is an agent definition: a system prompt, model, tools and loop
+// options.  Handoffs, the agents it may hand the conversation to, are used
+// only when it is a member of a Team.
+type Agent struct {
+	// Name identifies the agent and names its handoff tool, so it must be
+	// valid in a tool name (letters, digits, '_' and '-').
+	Name string
+	// Description tells other agents when to hand off to this one.
+	Description string
+	// SystemPrompt may be empty, in which case the agent runs with no
+	// system message.
+	SystemPrompt string
+	Model        InvokeModelFunc
+	Tools        []Tool
+	Handoffs     []string // names of the agents this one may transfer to
+	Options      []AgentLoopOption
+}
+
+// Team is a set of agents that pass one conversation between them.  Each
+// agent is offered a handoff tool for every agent in its Handoffs; calling
+// one ends its turn and the target agent continues the same session under
+// its own system prompt, model and tools.
+type Team struct {
+	Agents      []Agent // the first is active until a handoff happens
+	MaxHandoffs int     // per Run call; DefaultMaxHandoffs when zero
+}
+
+// Run continues session with the active agent (see ActiveAgent) until an
+// agent finishes without handing off, and returns the session and the name
+// of the agent now active.  The session records every handoff as a
+// tool call and result, so a later Run on it resumes with the same agent.
+// opts apply to every agent's loop, before the agent's own Options.
+func (t Team) Run(ctx context.Context, session Session, opts ...AgentLoopOption) (Session, string, error) {
+	agents := make(map[string]*Agent, len(t.Agents))
+	for i := range t.Agents {
+		agents[t.Agents[i].Name] = &t.Agents[i]
+	}
+	for _, a := range t.Agents {
+		if a.Model == nil {
+			return session, "", fmt.Errorf("agent %q has no model", a.Name)
+		}
+		for _, to := range a.Handoffs {
+			if _, ok := agents[to]; !ok {
+				return session, "", fmt.Errorf("agent %q hands off to unknown agent %q", a.Name, to)
+			}
+		}
+	}
+	active := t.ActiveAgent(session)
+	if active == "" {
+		return session, "", errors.New("team has no agents")
+	}
+	maxHandoffs := t.MaxHandoffs
+	if maxHandoffs == 0 {
+		maxHandoffs = DefaultMaxHandoffs
+	}
+
+	for handoffs := 0; ; handoffs++ {
+		a := agents[active]
+		h := &handoff{}
+		tools := a.Tools[:len(a.Tools):len(a.Tools)]
+		for _, to := range a.Handoffs {
+			tools = append(tools, h.tool(agents[to]))
+		}
+		loopOpts := append(opts[:len(opts):len(opts)], a.Options...)
+		loopOpts = append(loopOpts, func(c *agentLoopConfig) { c.halt = h.requested })
+
+		var err error
+		session, err = AgentLoop(ctx, a.Model, tools, withSystemPrompt(session, a.SystemPrompt), loopOpts...)
+		if err != nil {
+			return session, active, err
+		}
+		next := h.target()
+		if next == "" {
+			return session, active, nil
+		}
+		if handoffs == maxHandoffs {
+			return session, next, fmt.Errorf("%w (%d)", ErrMaxHandoffs, maxHandoffs)
+		}
+		active = next
+	}
+}
+
+// ActiveAgent returns the agent that the last successful handoff in s
+// transferred to, or the team's first agent if there was none.
+func (t Team) ActiveAgent(s Session) string {
+	if len(t.Agents) == 0 {
+		return ""
+	}
+	known := make(map[string]bool, len(t.Agents))
+	for _, a := range t.Agents {
+		known[a.Name] = true
+	}
+	active := t.Agents[0].Name
+	targets := map[string]string{}
+	for _, m := range s.Messages {
+		switch m := m.(type) {
+		case ToolCallMessage:
+			if to, ok := strings.CutPrefix(m.Name, HandoffToolPrefix); ok && known[to] {
+				targets[m.ID] = to
+			}
+		case ToolResultMessage:
+			if to, ok := targets[m.ID]; ok && !strings.HasPrefix(m.Output, "Error: ") {
+				active = to
+			}
+		}
+	}
+	return active
+}
+
+// withSystemPrompt returns s with its system messages replaced by prompt,
+// or removed if prompt is empty, since the API rejects an empty system
+// block and the previous agent's prompt does not apply.
+func withSystemPrompt(s Session, prompt string) Session {
+	out := Session{State: s.State}
+	if prompt != "" {
+		out.Add(SystemMessage{prompt})
+	}
+	for i, m := range s.Messages {
+		if _, ok := m.(SystemMessage); !ok {
+			out.addAt(s.Time(i), m)
+		}
+	}
+	return out
+}
+
+// handoff records the transfer requested during one agent's turn.
+type handoff struct {
+	mu sync.Mutex
+	to string
+}
+
+func (h *handoff) target() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.to
+}
+
+func (h *handoff) requested() bool { return h.target() != "" }
+
+func (h *handoff) tool(to *Agent) Tool {
+	desc := fmt.Sprintf("Hand the conversation over to the %s agent, which will continue it with the user.", to.Name)
+	if to.Description != "" {
+		desc += " " + to.Description
+	}
+	return Tool{
+		Definition: ToolDefinition{
+			Name:        HandoffToolPrefix + to.Name,
+			Description: desc,
+			InputSchema: ToolInputSchema{
+				Type: "object",
+				Properties: map[string]any{
+					"reason": map[string]any{
+						"type":        "string",
+						"description": "Why the conversation is being handed over, and anything the next agent needs to know.",
+					},
+				},
+			},
+		},
+		Handler: func(context.Context, json.RawMessage) (string, error) {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if h.to != "" && h.to != to.Name {
+				return "", fmt.Errorf("already transferring to %s", h.to)
+			}
+			h.to = to.Name
+			return fmt.Sprintf("Transferred to %s.", to.Name), nil
+		},
+	}
+}
@@ -0,0 +1,110 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// recordingModel returns a model that logs the system prompt and tool names
+// it is called with and replies via respond.
+func recordingModel(name string, log *[]string, respond func(Session) Message) InvokeModelFunc {
+	return func(_ context.Context, defs []ToolDefinition, s Session) ([]Message, Usage, error) {
+		entry := name + ":"
+		if sys, ok := s.Messages[0].(SystemMessage); ok {
+			entry += " " + sys.Content
+		}
+		for _, d := range defs {
+			entry += " " + d.Name
+		}
+		*log = append(*log, entry)
+		return []Message{respond(s)}, Usage{}, nil
+	}
+}
+
+func TestTeamHandoff(t *testing.T) {
+	var log []string
+	transfer := func(Session) Message {
+		return ToolCallMessage{ID: "h1", Name: "transfer_to_billing", Input: json.RawMessage(`{"reason":"refund"}`)}
+	}
+	team := Team{Agents: []Agent{
+		{Name: "triage", SystemPrompt: "Route.", Model: recordingModel("triage", &log, transfer),
+			Handoffs: []string{"billing", "technical"}},
+		{Name: "billing", Description: "Handles refunds.", SystemPrompt: "Bill.",
+			Model: recordingModel("billing", &log, func(Session) Message { return AssistantMessage{"Refunded."} }),
+			Tools: []Tool{noopTool}},
+		{Name: "technical", SystemPrompt: "Fix.", Model: recordingModel("technical", &log, transfer)},
+	}}
+
+	session, active, err := team.Run(context.Background(), InitSession("", "I was double charged."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active != "billing" || team.ActiveAgent(session) != "billing" {
+		t.Errorf("active = %q, ActiveAgent = %q", active, team.ActiveAgent(session))
+	}
+	want := []string{
+		"triage: Route. transfer_to_billing transfer_to_technical",
+		"billing: Bill. noop",
+	}
+	if len(log) != len(want) || log[0] != want[0] || log[1] != want[1] {
+		t.Errorf("calls:\n%q\nwant:\n%q", log, want)
+	}
+	if r, ok := session.Messages[3].(ToolResultMessage); !ok || r.Output != "Transferred to billing." {
+		t.Errorf("handoff result: %#v", session.Messages[3])
+	}
+
+	// The next turn resumes with billing.
+	log = nil
+	session.Add(UserMessage{"Thanks!"})
+	if _, active, err = team.Run(context.Background(), session); err != nil || active != "billing" || len(log) != 1 {
+		t.Errorf("resumed with %q, %v; calls %q", active, err, log)
+	}
+}
+
+// TestTeamEmptySystemPrompt checks that an agent without a system prompt
+// runs with no system message rather than an empty one or the previous
+// agent's.
+func TestTeamEmptySystemPrompt(t *testing.T) {
+	var log []string
+	team := Team{Agents: []Agent{
+		{Name: "triage", SystemPrompt: "Route.", Handoffs: []string{"general"},
+			Model: recordingModel("triage", &log, func(Session) Message {
+				return ToolCallMessage{ID: "h1", Name: "transfer_to_general", Input: json.RawMessage(`{}`)}
+			})},
+		{Name: "general", Model: recordingModel("general", &log, func(Session) Message { return AssistantMessage{"Hi."} })},
+	}}
+	session, _, err := team.Run(context.Background(), InitSession("Old.", "hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 2 || log[1] != "general:" {
+		t.Errorf("calls: %q", log)
+	}
+	if n := len(MessagesOf[SystemMessage](session)); n != 0 {
+		t.Errorf("%d system messages left in the session", n)
+	}
+}
+
+func TestTeamMaxHandoffs(t *testing.T) {
+	var log []string
+	to := func(name string) func(Session) Message {
+		return func(Session) Message {
+			return ToolCallMessage{ID: "h", Name: HandoffToolPrefix + name, Input: json.RawMessage(`{}`)}
+		}
+	}
+	team := Team{MaxHandoffs: 3, Agents: []Agent{
+		{Name: "a", Model: recordingModel("a", &log, to("b")), Handoffs: []string{"b"}},
+		{Name: "b", Model: recordingModel("b", &log, to("a")), Handoffs: []string{"a"}},
+	}}
+	_, _, err := team.Run(context.Background(), InitSession("sys", "hi"))
+	if !errors.Is(err, ErrMaxHandoffs) || len(log) != 4 {
+		t.Errorf("got %v after %d calls", err, len(log))
+	}
+
+	team.Agents[1].Handoffs = []string{"c"}
+	if _, _, err := team.Run(context.Background(), InitSession("sys", "hi")); err == nil {
+		t.Error("expected an error for an unknown handoff target")
+	}
+}
@@ -8,10 +8,20 @@ import (
 
 // Usage holds token consumption figures from a single model invocation.
 type Usage struct {
-	InputTokens              int64
-	OutputTokens             int64
-	CacheCreationInputTokens int64
-	CacheReadInputTokens     int64
+	InputTokens              int64 `json:"input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+}
+
+// Add returns the field-wise sum of u and o.
+func (u Usage) Add(o Usage) Usage {
+	return Usage{
+		InputTokens:              u.InputTokens + o.InputTokens,
+		OutputTokens:             u.OutputTokens + o.OutputTokens,
+		CacheCreationInputTokens: u.CacheCreationInputTokens + o.CacheCreationInputTokens,
+		CacheReadInputTokens:     u.CacheReadInputTokens + o.CacheReadInputTokens,
+	}
 }
 
 // InvokeModelFunc is the generic model invocation interface used by AgentLoop.
@@ -31,57 +41,19 @@ func InvokeClaude(opts ...Option) InvokeModelFunc {
 
 // invokeClaude is the internal implementation.  It accepts an explicit client
 // so that tests can inject a pre-configured one without exposing the client to
-// callers of the exported API.
-//
-// Conversion rules:
-//   - SystemMessage        → params.System (TextBlockParam)
-//   - UserMessage          → user turn, text block
-//   - AssistantMessage     → assistant turn, text block
-//   - ThinkingMessage      → skipped (no API signature; kept in session for display only)
-//   - ToolCallMessage      → assistant turn, tool_use block
-//   - ToolResultMessage    → user turn, tool_result block
-//
-// Consecutive messages of the same role are merged into a single turn.
+// callers of the exported API.  tools replaces any WithTools option.
 func invokeClaude(ctx context.Context, client *Claude, tools []ToolDefinition, session Session, opts ...Option) ([]Message, Usage, error) {
-	system, messages := buildParams(session)
-
-	cfg := &completeConfig{
-		model:     client.model,
-		maxTokens: 4096,
-	}
-	for _, o := range opts {
-		o(cfg)
-	}
-
-	params := anthropic.MessageNewParams{
-		Model:     cfg.model,
-		MaxTokens: cfg.maxTokens,
-		Messages:  messages,
-	}
-	if len(system) > 0 {
-		system[len(system)-1].CacheControl = anthropic.NewCacheControlEphemeralParam()
-		params.System = system
-	}
-	if cfg.thinking != nil {
-		params.Thinking = anthropic.ThinkingConfigParamOfEnabled(*cfg.thinking)
-	}
-	if len(tools) > 0 {
-		toolParams := toolDefsToParams(tools)
-		toolParams[len(toolParams)-1].OfTool.CacheControl = anthropic.NewCacheControlEphemeralParam()
-		params.Tools = toolParams
-	}
+	return client.Chat(ctx, session, append(opts[:len(opts):len(opts)], WithTools(tools...))...)
+}
 
-	resp, err := client.api.Messages.New(ctx, params)
-	if err != nil {
-		return nil, Usage{}, err
-	}
-	usage := Usage{
+// usageOf extracts token usage from an API response.
+func usageOf(resp *anthropic.Message) Usage {
+	return Usage{
 		InputTokens:              resp.Usage.InputTokens,
 		OutputTokens:             resp.Usage.OutputTokens,
 		CacheCreationInputTokens: resp.Usage.CacheCreationInputTokens,
 		CacheReadInputTokens:     resp.Usage.CacheReadInputTokens,
 	}
-	return responseToMessages(resp), usage, nil
 }
 
 // buildParams converts a Session into the system blocks and message turns
@@ -0,0 +1,354 @@
+package agentloop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// SchemaFor derives a tool input schema from the Go type T.
+//
+// Struct fields are named by their json tags and are required unless tagged
+// omitempty; a `description:"..."` tag documents a field and an
+// `enum:"a,b,c"` tag restricts a string field to the listed values.  Since
+// tool inputs must be objects, a T that does not encode as a JSON object is
+// wrapped as the single required property "answer".
+func SchemaFor[T any]() ToolInputSchema {
+	schema, _ := schemaFor(reflect.TypeFor[T]())
+	return schema
+}
+
+// schemaFor returns the tool input schema for t and whether t was wrapped
+// under an "answer" property.
+func schemaFor(t reflect.Type) (ToolInputSchema, bool) {
+	s := typeSchema(t, map[reflect.Type]bool{})
+	if s["type"] != "object" {
+		return ToolInputSchema{
+			Type:       "object",
+			Properties: map[string]any{"answer": s},
+			Required:   []string{"answer"},
+		}, true
+	}
+	out := ToolInputSchema{Type: "object"}
+	if props, ok := s["properties"].(map[string]any); ok {
+		out.Properties = props
+	}
+	if req, ok := s["required"].([]string); ok {
+		out.Required = req
+	}
+	return out, false
+}
+
+var (
+	timeType       = reflect.TypeFor[time.Time]()
+	rawMessageType = reflect.TypeFor[json.RawMessage]()
+)
+
+// typeSchema returns the JSON Schema for t.  seen guards against recursive
+// types, which are described as accepting any value.
+func typeSchema(t reflect.Type, seen map[reflect.Type]bool) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]any{}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		props := map[string]any{}
+		var required []string
+		structFields(t, seen, props, &required)
+		s := map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	default:
+		return map[string]any{}
+	}
+}
+
+// structFields adds the schemas of t's encoded fields to props, flattening
+// embedded structs the way encoding/json does.
+func structFields(t reflect.Type, seen map[reflect.Type]bool, props map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				structFields(ft, seen, props, required)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		s := typeSchema(f.Type, seen)
+		if d := f.Tag.Get("description"); d != "" {
+			s["description"] = d
+		}
+		if e := f.Tag.Get("enum"); e != "" {
+			var values []any
+			for _, v := range strings.Split(e, ",") {
+				values = append(values, strings.TrimSpace(v))
+			}
+			s["enum"] = values
+		}
+		props[name] = s
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// normalizeSchema converts a schema built from Go values (nested maps,
+// []string, ...) into its generic JSON form for validation.
+func normalizeSchema(schema ToolInputSchema) (map[string]any, error) {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// validateJSON checks data against a JSON Schema in generic form.  It
+// supports the keywords tool schemas commonly use: type, enum, const,
+// properties, required, additionalProperties, items, minItems, maxItems,
+// minLength, maxLength, pattern, minimum and maximum.  All problems found
+// are returned, one per line.
+func validateJSON(schema map[string]any, data json.RawMessage) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	var problems []string
+	validateValue(schema, v, "$", &problems)
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// jsonType returns the JSON Schema type name of a decoded value.
+func jsonType(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := x.Int64(); err == nil {
+			return "integer"
+		}
+		if f, err := x.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func validateValue(schema map[string]any, v any, path string, problems *[]string) {
+	fail := func(format string, args ...any) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if t, ok := schema["type"]; ok {
+		var allowed []string
+		switch t := t.(type) {
+		case string:
+			allowed = []string{t}
+		case []any:
+			for _, a := range t {
+				if s, ok := a.(string); ok {
+					allowed = append(allowed, s)
+				}
+			}
+		}
+		got := jsonType(v)
+		match := false
+		for _, a := range allowed {
+			if a == got || (a == "number" && got == "integer") {
+				match = true
+			}
+		}
+		if !match {
+			fail("got %s, want %s", got, strings.Join(allowed, " or "))
+			return
+		}
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if JSONEqual(e, v) {
+				found = true
+			}
+		}
+		if !found {
+			fail("value %s is not one of %s", compactJSON(v), compactJSON(enum))
+		}
+	}
+	if c, ok := schema["const"]; ok && !JSONEqual(c, v) {
+		fail("value %s is not %s", compactJSON(v), compactJSON(c))
+	}
+
+	switch x := v.(type) {
+	case map[string]any:
+		props, _ := schema["properties"].(map[string]any)
+		if req, ok := schema["required"].([]any); ok {
+			for _, r := range req {
+				if name, ok := r.(string); ok {
+					if _, present := x[name]; !present {
+						fail("missing required property %q", name)
+					}
+				}
+			}
+		}
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if ps, ok := props[k].(map[string]any); ok {
+				validateValue(ps, x[k], path+"."+k, problems)
+				continue
+			}
+			switch ap := schema["additionalProperties"].(type) {
+			case bool:
+				if !ap {
+					fail("unexpected property %q", k)
+				}
+			case map[string]any:
+				validateValue(ap, x[k], path+"."+k, problems)
+			}
+		}
+	case []any:
+		if n, ok := schemaNumber(schema, "minItems"); ok && float64(len(x)) < n {
+			fail("has %d items, want at least %v", len(x), n)
+		}
+		if n, ok := schemaNumber(schema, "maxItems"); ok && float64(len(x)) > n {
+			fail("has %d items, want at most %v", len(x), n)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range x {
+				validateValue(items, item, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(x)
+		if m, ok := schemaNumber(schema, "minLength"); ok && float64(n) < m {
+			fail("length %d is shorter than %v", n, m)
+		}
+		if m, ok := schemaNumber(schema, "maxLength"); ok && float64(n) > m {
+			fail("length %d is longer than %v", n, m)
+		}
+		if p, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(p); err == nil && !re.MatchString(x) {
+				fail("%q does not match pattern %s", x, p)
+			}
+		}
+	case json.Number:
+		f, _ := x.Float64()
+		if m, ok := schemaNumber(schema, "minimum"); ok && f < m {
+			fail("%v is less than %v", x, m)
+		}
+		if m, ok := schemaNumber(schema, "maximum"); ok && f > m {
+			fail("%v is greater than %v", x, m)
+		}
+	}
+}
+
+// schemaNumber returns a numeric schema keyword.
+func schemaNumber(schema map[string]any, key string) (float64, bool) {
+	n, ok := schema[key].(float64)
+	return n, ok
+}
+
+// JSONEqual reports whether a and b encode to the same JSON, ignoring key
+// order and number formatting.
+func JSONEqual(a, b any) bool {
+	return canonicalJSON(a) == canonicalJSON(b)
+}
+
+// canonicalJSON encodes v with sorted keys and numbers in a common form.
+func canonicalJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	var out any
+	if json.Unmarshal(data, &out) != nil {
+		return string(data)
+	}
+	data, _ = json.Marshal(out)
+	return string(data)
+}
+
+// compactJSON renders v for error messages.
+func compactJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,255 @@
+package agentloop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotRun is the error of a MapResult whose input was never started
+// because the context was cancelled first.
+var ErrNotRun = errors.New("not run")
+
+// RateLimiter spaces out calls evenly, for sharing a request rate between
+// concurrent loops.  A RateLimiter is safe for concurrent use.
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewRateLimiter returns a limiter allowing n calls per period.
+func NewRateLimiter(n int, per time.Duration) *RateLimiter {
+	return &RateLimiter{interval: per / time.Duration(max(n, 1))}
+}
+
+// Wait blocks until the caller's turn or until ctx is done.  A turn
+// reserved by a cancelled Wait is not given back.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	at := l.next
+	if now := time.Now(); at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	d := time.Until(at)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RateLimited wraps model so every call first waits its turn on l.
+func RateLimited(model InvokeModelFunc, l *RateLimiter) InvokeModelFunc {
+	return func(ctx context.Context, tools []ToolDefinition, s Session) ([]Message, Usage, error) {
+		if err := l.Wait(ctx); err != nil {
+			return nil, Usage{}, err
+		}
+		return model(ctx, tools, s)
+	}
+}
+
+// MapResult is the outcome of running the agent on one input.
+type MapResult struct {
+	Input   string
+	Output  string  // extracted from Session; empty if Err is set
+	Session Session // the agent's session, possibly partial if Err is set
+	Err     error
+}
+
+// MapOption configures Map and MapReduce.
+type MapOption func(*mapConfig)
+
+type mapConfig struct {
+	workers  int
+	limiter  *RateLimiter
+	result   ResultFunc
+	failFast bool
+	reduce   func([]MapResult) string
+}
+
+// WithWorkers sets how many inputs are processed at once (default 4).
+func WithWorkers(n int) MapOption {
+	return func(c *mapConfig) { c.workers = n }
+}
+
+// WithRateLimit makes every model call, by any worker or the reducer, wait
+// its turn on l.
+func WithRateLimit(l *RateLimiter) MapOption {
+	return func(c *mapConfig) { c.limiter = l }
+}
+
+// WithMapResult sets how each output is extracted from its session
+// (default ResultLastText).
+func WithMapResult(fn ResultFunc) MapOption {
+	return func(c *mapConfig) { c.result = fn }
+}
+
+// WithFailFast cancels the remaining inputs as soon as one fails.  By
+// default every input runs and failures are reported per result.
+func WithFailFast() MapOption {
+	return func(c *mapConfig) { c.failFast = true }
+}
+
+// WithReducePrompt sets how MapReduce turns the map results into the
+// reducer's prompt (default ReducePrompt).
+func WithReducePrompt(fn func([]MapResult) string) MapOption {
+	return func(c *mapConfig) { c.reduce = fn }
+}
+
+// Map runs agent once per input, each input being the first user message
+// of a fresh session, with a bounded number running at once.  Results are
+// in input order.  A failed input records its error in its result without
+// affecting the others; the returned error is non-nil only if ctx was
+// cancelled or, with WithFailFast, an input failed.  Inputs not started by
+// then fail with ErrNotRun.  When ctx carries a budget, usage is recorded
+// under one sub-budget named after the agent, shared by every input.
+func Map(ctx context.Context, agent Agent, inputs []string, opts ...MapOption) ([]MapResult, error) {
+	cfg := mapOptions(opts)
+	if cfg.limiter != nil {
+		agent.Model = RateLimited(agent.Model, cfg.limiter)
+	}
+
+	ctx = agent.budget(ctx)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	results := make([]MapResult, len(inputs))
+	for i, in := range inputs {
+		results[i] = MapResult{Input: in, Err: ErrNotRun}
+	}
+
+	sem := make(chan struct{}, max(cfg.workers, 1))
+	var wg sync.WaitGroup
+
+dispatch:
+	for i, in := range inputs {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break dispatch
+		}
+		wg.Add(1)
+		go func(i int, in string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			r := MapResult{Input: in}
+			r.Session, r.Err = agent.run(ctx, in)
+			if r.Err == nil {
+				r.Output, r.Err = cfg.result(r.Session)
+			}
+			results[i] = r
+			if r.Err != nil && cfg.failFast {
+				cancel(fmt.Errorf("input %d: %w", i, r.Err))
+			}
+		}(i, in)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return results, context.Cause(ctx)
+	}
+	return results, nil
+}
+
+// MapReduce runs Map and then reducer over the successful outputs, with
+// the prompt built by ReducePrompt or WithReducePrompt.  It returns the
+// reducer's final answer and the map results.  Failed inputs do not stop
+// the reduce step unless WithFailFast is set, but if every input failed
+// there is nothing to reduce and the error reports the first failure.
+func MapReduce(ctx context.Context, mapper Agent, inputs []string, reducer Agent, opts ...MapOption) (string, []MapResult, error) {
+	results, err := Map(ctx, mapper, inputs, opts...)
+	if err != nil {
+		return "", results, err
+	}
+	var firstErr error
+	ok := 0
+	for _, r := range results {
+		if r.Err == nil {
+			ok++
+		} else if firstErr == nil {
+			firstErr = r.Err
+		}
+	}
+	if ok == 0 && firstErr != nil {
+		return "", results, fmt.Errorf("every input failed: %w", firstErr)
+	}
+
+	cfg := mapOptions(opts)
+	if cfg.limiter != nil {
+		reducer.Model = RateLimited(reducer.Model, cfg.limiter)
+	}
+	session, err := reducer.run(reducer.budget(ctx), cfg.reduce(results))
+	if err != nil {
+		return "", results, fmt.Errorf("reduce: %w", err)
+	}
+	out, err := cfg.result(session)
+	if err != nil {
+		return "", results, fmt.Errorf("reduce: %w", err)
+	}
+	return out, results, nil
+}
+
+// ReducePrompt is the default reducer prompt: each successful output
+// wrapped in a numbered <result> tag, followed by a count of the failures.
+func ReducePrompt(results []MapResult) string {
+	var sb strings.Builder
+	sb.WriteString("Combine these results into one answer.\n")
+	failed := 0
+	for i, r := range results {
+		if r.Err != nil {
+			failed++
+			continue
+		}
+		fmt.Fprintf(&sb, "\n<result index=\"%d\">\n%s\n</result>\n", i+1, r.Output)
+	}
+	if failed > 0 {
+		fmt.Fprintf(&sb, "\n%d of %d inputs failed and are not included.\n", failed, len(results))
+	}
+	return sb.String()
+}
+
+func mapOptions(opts []MapOption) *mapConfig {
+	cfg := &mapConfig{workers: 4, result: ResultLastText, reduce: ReducePrompt}
+	for _, o := range opts {
+		o(cfg)
+	}
+	return cfg
+}
+
+// budget returns ctx with a child of its budget named after the agent, for
+// the runs of one Map, reduce or PlanAndExecute call to share.  ctx is
+// returned unchanged if it has no budget or the agent no name.
+func (a Agent) budget(ctx context.Context) context.Context {
+	parent, ok := BudgetFromContext(ctx)
+	if !ok || a.Name == "" {
+		return ctx
+	}
+	return ContextWithBudget(ctx, parent.Child(a.Name, BudgetLimits{}))
+}
+
+// run runs the agent's loop on a fresh session seeded with prompt, and
+// with the agent's system prompt if it has one.
+func (a Agent) run(ctx context.Context, prompt string) (Session, error) {
+	session := Session{}
+	if a.SystemPrompt != "" {
+		session.Add(SystemMessage{a.SystemPrompt})
+	}
+	session.Add(UserMessage{prompt})
+	return AgentLoop(ctx, a.Model, a.Tools, session, a.Options...)
+}
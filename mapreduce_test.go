@@ -0,0 +1,120 @@
+package agentloop
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// upperAgent replies with its prompt upper-cased, failing on "bad", and
+// records the peak number of concurrent calls.
+func upperAgent(peak *int) Agent {
+	var mu sync.Mutex
+	running := 0
+	return Agent{Name: "upper", SystemPrompt: "sys", Model: func(ctx context.Context, _ []ToolDefinition, s Session) ([]Message, Usage, error) {
+		mu.Lock()
+		running++
+		*peak = max(*peak, running)
+		mu.Unlock()
+		defer func() { mu.Lock(); running--; mu.Unlock() }()
+
+		time.Sleep(5 * time.Millisecond)
+		in := s.Messages[1].(UserMessage).Content
+		if in == "bad" {
+			return nil, Usage{}, errors.New("model failed")
+		}
+		return []Message{AssistantMessage{strings.ToUpper(in)}}, Usage{}, nil
+	}}
+}
+
+func TestMap(t *testing.T) {
+	var peak int
+	results, err := Map(context.Background(), upperAgent(&peak), []string{"a", "bad", "c", "d", "e"}, WithWorkers(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"A", "", "C", "D", "E"} {
+		if results[i].Output != want || (results[i].Err != nil) != (i == 1) {
+			t.Errorf("result %d: %+v", i, results[i])
+		}
+	}
+	if peak != 2 {
+		t.Errorf("peak concurrency %d, want 2", peak)
+	}
+
+	results, err = Map(context.Background(), upperAgent(&peak), []string{"bad", "b", "c", "d"}, WithWorkers(1), WithFailFast())
+	if err == nil || !strings.Contains(err.Error(), "input 0: model failed") {
+		t.Errorf("fail fast: got %v", err)
+	}
+	if !errors.Is(results[3].Err, ErrNotRun) {
+		t.Errorf("fail fast: last result %+v", results[3])
+	}
+}
+
+func TestMapReduce(t *testing.T) {
+	var peak int
+	var prompt string
+	// The reducer has no system prompt, so its session starts with the
+	// user message rather than an empty system message.
+	reducer := Agent{Model: func(_ context.Context, _ []ToolDefinition, s Session) ([]Message, Usage, error) {
+		prompt = s.Messages[0].(UserMessage).Content
+		return []Message{AssistantMessage{"combined"}}, Usage{}, nil
+	}}
+	out, results, err := MapReduce(context.Background(), upperAgent(&peak), []string{"x", "bad"}, reducer)
+	if err != nil || out != "combined" || len(results) != 2 {
+		t.Fatalf("got %q, %v", out, err)
+	}
+	want := "Combine these results into one answer.\n\n<result index=\"1\">\nX\n</result>\n\n1 of 2 inputs failed and are not included.\n"
+	if prompt != want {
+		t.Errorf("reduce prompt:\n%s\nwant:\n%s", prompt, want)
+	}
+
+	if _, _, err := MapReduce(context.Background(), upperAgent(&peak), []string{"bad"}, reducer); err == nil {
+		t.Error("expected an error when every input fails")
+	}
+}
+
+// TestMapBudget checks that one Map call records all of its inputs under a
+// single sub-budget named after the agent.
+func TestMapBudget(t *testing.T) {
+	var peak int
+	agent := upperAgent(&peak)
+	upper := agent.Model
+	agent.Model = func(ctx context.Context, defs []ToolDefinition, s Session) ([]Message, Usage, error) {
+		msgs, _, err := upper(ctx, defs, s)
+		return msgs, Usage{InputTokens: 10}, err
+	}
+	root := NewBudget("run", BudgetLimits{})
+	ctx := ContextWithBudget(context.Background(), root)
+	if _, err := Map(ctx, agent, []string{"a", "b", "c"}, WithWorkers(3)); err != nil {
+		t.Fatal(err)
+	}
+	r := root.Report()
+	if len(r.Children) != 1 || r.Children[0].Name != "upper" || r.Children[0].Usage.InputTokens != 30 {
+		t.Errorf("report:\n%s", r)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(100, time.Second)
+	start := time.Now()
+	for range 4 {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("four calls took %v, want at least 30ms", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l = NewRateLimiter(1, time.Hour)
+	l.Wait(ctx)
+	if err := l.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,234 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NotebookStateKey is the Session.State key under which WithNotebook keeps
+// the agent's notebook.
+const NotebookStateKey = "notebook"
+
+// TodoStatus is the progress of a todo item.
+type TodoStatus string
+
+const (
+	TodoPending    TodoStatus = "pending"
+	TodoInProgress TodoStatus = "in_progress"
+	TodoDone       TodoStatus = "done"
+)
+
+// TodoItem is one entry of a Notebook's todo list.
+type TodoItem struct {
+	ID     int        `json:"id"`
+	Text   string     `json:"text"`
+	Status TodoStatus `json:"status"`
+}
+
+// Notebook is an agent's working memory: a todo list and named scratchpad
+// notes, kept in Session.State so compaction never truncates it.
+type Notebook struct {
+	Todos []TodoItem        `json:"todos,omitempty"`
+	Notes map[string]string `json:"notes,omitempty"`
+}
+
+// NotebookFromSession returns the notebook stored in s by WithNotebook, or
+// an empty one.
+func NotebookFromSession(s Session) (Notebook, error) {
+	var n Notebook
+	_, err := s.GetState(NotebookStateKey, &n)
+	return n, err
+}
+
+// Markdown renders the todo list as a checklist followed by the notes.
+func (n Notebook) Markdown() string {
+	var sb strings.Builder
+	n.writeTodos(&sb)
+	if len(n.Notes) > 0 {
+		if len(n.Todos) > 0 {
+			sb.WriteString("\n")
+		}
+		n.writeNotes(&sb)
+	}
+	return sb.String()
+}
+
+func (n Notebook) writeTodos(sb *strings.Builder) {
+	for _, t := range n.Todos {
+		box := "[ ]"
+		switch t.Status {
+		case TodoInProgress:
+			box = "[~]"
+		case TodoDone:
+			box = "[x]"
+		}
+		fmt.Fprintf(sb, "- %s %d. %s\n", box, t.ID, t.Text)
+	}
+}
+
+func (n Notebook) writeNotes(sb *strings.Builder) {
+	for _, k := range n.noteKeys() {
+		fmt.Fprintf(sb, "**%s**\n\n%s\n\n", k, n.Notes[k])
+	}
+}
+
+func (n Notebook) noteKeys() []string {
+	keys := make([]string, 0, len(n.Notes))
+	for k := range n.Notes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WithNotebook gives the agent tools to keep a todo list and scratchpad
+// notes: todo_add, todo_update, todo_list, notes_write and notes_read.  The
+// notebook starts from the one stored in the session, if any, and is saved
+// back to the session's State after every batch of tool calls, so it is
+// serialised with the session and carried into later runs (see
+// NotebookFromSession).
+func WithNotebook() AgentLoopOption {
+	return func(c *agentLoopConfig) { c.notebook = true }
+}
+
+// notebook is the live notebook behind the tools of one loop.  Tool calls
+// run concurrently, so every access holds mu.
+type notebook struct {
+	mu sync.Mutex
+	n  Notebook
+}
+
+func (nb *notebook) snapshot() Notebook {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	n := Notebook{Todos: slices.Clone(nb.n.Todos)}
+	if len(nb.n.Notes) > 0 {
+		n.Notes = make(map[string]string, len(nb.n.Notes))
+		for k, v := range nb.n.Notes {
+			n.Notes[k] = v
+		}
+	}
+	return n
+}
+
+// todoList returns the todo list as a tool result.  The caller holds mu.
+func (nb *notebook) todoList() string {
+	if len(nb.n.Todos) == 0 {
+		return "The todo list is empty."
+	}
+	var sb strings.Builder
+	nb.n.writeTodos(&sb)
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+type (
+	todoAddInput struct {
+		Items []string `json:"items" description:"The tasks to add, in order."`
+	}
+	todoUpdateInput struct {
+		ID     int        `json:"id" description:"The item to update."`
+		Status TodoStatus `json:"status,omitempty" enum:"pending,in_progress,done" description:"The item's new status."`
+		Text   string     `json:"text,omitempty" description:"The item's new text."`
+	}
+	notesWriteInput struct {
+		Key     string `json:"key" description:"The note's name."`
+		Content string `json:"content" description:"The note's full new content. Empty deletes the note."`
+	}
+	notesReadInput struct {
+		Key string `json:"key,omitempty" description:"The note to read. Omit to read every note."`
+	}
+)
+
+// notebookTool builds a tool whose input is decoded into an In before fn
+// runs with the notebook locked.
+func notebookTool[In any](nb *notebook, name, description string, fn func(In) (string, error)) Tool {
+	return Tool{
+		Definition: ToolDefinition{Name: name, Description: description, InputSchema: SchemaFor[In]()},
+		Handler: func(_ context.Context, input json.RawMessage) (string, error) {
+			var in In
+			if err := json.Unmarshal(input, &in); err != nil {
+				return "", fmt.Errorf("invalid input: %w", err)
+			}
+			nb.mu.Lock()
+			defer nb.mu.Unlock()
+			return fn(in)
+		},
+	}
+}
+
+func (nb *notebook) tools() []Tool {
+	return []Tool{
+		notebookTool(nb, "todo_add", "Add tasks to your todo list. Returns the updated list.",
+			func(in todoAddInput) (string, error) {
+				if len(in.Items) == 0 {
+					return "", errors.New("items is required")
+				}
+				next := 1
+				for _, t := range nb.n.Todos {
+					next = max(next, t.ID+1)
+				}
+				for _, text := range in.Items {
+					nb.n.Todos = append(nb.n.Todos, TodoItem{ID: next, Text: text, Status: TodoPending})
+					next++
+				}
+				return nb.todoList(), nil
+			}),
+		notebookTool(nb, "todo_update", "Change a todo item's status or text, e.g. mark it done. Returns the updated list.",
+			func(in todoUpdateInput) (string, error) {
+				i := slices.IndexFunc(nb.n.Todos, func(t TodoItem) bool { return t.ID == in.ID })
+				if i < 0 {
+					return "", fmt.Errorf("no todo item %d", in.ID)
+				}
+				switch in.Status {
+				case "":
+				case TodoPending, TodoInProgress, TodoDone:
+					nb.n.Todos[i].Status = in.Status
+				default:
+					return "", fmt.Errorf("unknown status %q", in.Status)
+				}
+				if in.Text != "" {
+					nb.n.Todos[i].Text = in.Text
+				}
+				return nb.todoList(), nil
+			}),
+		notebookTool(nb, "todo_list", "Show your todo list.",
+			func(struct{}) (string, error) { return nb.todoList(), nil }),
+		notebookTool(nb, "notes_write", "Save a scratchpad note under a name, replacing any note with that name. Notes are kept in full for the rest of the task.",
+			func(in notesWriteInput) (string, error) {
+				if in.Key == "" {
+					return "", errors.New("key is required")
+				}
+				if in.Content == "" {
+					delete(nb.n.Notes, in.Key)
+					return fmt.Sprintf("Deleted note %q.", in.Key), nil
+				}
+				if nb.n.Notes == nil {
+					nb.n.Notes = map[string]string{}
+				}
+				nb.n.Notes[in.Key] = in.Content
+				return fmt.Sprintf("Saved note %q.", in.Key), nil
+			}),
+		notebookTool(nb, "notes_read", "Read a scratchpad note, or every note.",
+			func(in notesReadInput) (string, error) {
+				if in.Key != "" {
+					content, ok := nb.n.Notes[in.Key]
+					if !ok {
+						return "", fmt.Errorf("no note %q", in.Key)
+					}
+					return content, nil
+				}
+				if len(nb.n.Notes) == 0 {
+					return "There are no notes.", nil
+				}
+				var sb strings.Builder
+				nb.n.writeNotes(&sb)
+				return strings.TrimSpace(sb.String()), nil
+			}),
+	}
+}
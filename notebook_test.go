@@ -0,0 +1,75 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func toolCall(id, name, input string) ToolCallMessage {
+	return ToolCallMessage{ID: id, Name: name, Input: json.RawMessage(input)}
+}
+
+func TestNotebook(t *testing.T) {
+	model, _ := scriptedModel(
+		[]Message{toolCall("t1", "todo_add", `{"items":["copy rows","verify counts"]}`)},
+		[]Message{
+			toolCall("t2", "todo_update", `{"id":1,"status":"done"}`),
+			toolCall("n1", "notes_write", `{"key":"source","content":"orders table, 1.2M rows"}`),
+		},
+		[]Message{toolCall("t3", "todo_update", `{"id":9,"status":"done"}`)},
+	)
+	session, err := AgentLoop(context.Background(), model, nil, InitSession("sys", "Migrate."), WithNotebook())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := MessagesOf[ToolResultMessage](session)
+	if results[0].Output != "- [ ] 1. copy rows\n- [ ] 2. verify counts" {
+		t.Errorf("todo_add result: %q", results[0].Output)
+	}
+	if results[3].Output != "Error: no todo item 9" {
+		t.Errorf("todo_update result: %q", results[3].Output)
+	}
+
+	n, err := NotebookFromSession(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Notebook{
+		Todos: []TodoItem{{1, "copy rows", TodoDone}, {2, "verify counts", TodoPending}},
+		Notes: map[string]string{"source": "orders table, 1.2M rows"},
+	}
+	if !reflect.DeepEqual(n, want) {
+		t.Errorf("notebook: %+v, want %+v", n, want)
+	}
+	md := RenderMarkdown(session)
+	if !strings.Contains(md, "## Notebook\n\n- [x] 1. copy rows\n- [ ] 2. verify counts\n\n**source**\n\norders table, 1.2M rows\n\n## System") {
+		t.Errorf("rendered notebook missing:\n%s", md)
+	}
+
+	// A later run, here after a round trip through JSON, picks the
+	// notebook up where the last one left it.
+	data, _ := json.Marshal(session)
+	var resumed Session
+	if err := json.Unmarshal(data, &resumed); err != nil {
+		t.Fatal(err)
+	}
+	resumed.Add(UserMessage{"Continue."})
+	model, _ = scriptedModel(
+		[]Message{toolCall("t4", "todo_add", `{"items":["drop old table"]}`), toolCall("n2", "notes_read", `{}`)},
+	)
+	resumed, err = AgentLoop(context.Background(), model, nil, resumed, WithNotebook())
+	if err != nil {
+		t.Fatal(err)
+	}
+	results = MessagesOf[ToolResultMessage](resumed)
+	if got := results[len(results)-2].Output; !strings.HasSuffix(got, "- [ ] 3. drop old table") {
+		t.Errorf("todo_add after resume: %q", got)
+	}
+	if got := results[len(results)-1].Output; got != "**source**\n\norders table, 1.2M rows" {
+		t.Errorf("notes_read: %q", got)
+	}
+}
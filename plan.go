@@ -0,0 +1,278 @@
+package agentloop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PlanStateKey is the Session.State key under which PlanAndExecute keeps
+// its plan.
+const PlanStateKey = "plan"
+
+// DefaultMaxPlanSteps limits the steps PlanAndExecute executes in one call
+// unless overridden with WithMaxSteps.
+const DefaultMaxPlanSteps = 20
+
+// DefaultPlannerPrompt is the planner's system prompt unless overridden
+// with WithPlannerPrompt.
+const DefaultPlannerPrompt = `You plan work for an assistant that has tools.
+Break the goal into a short sequence of concrete steps, each a self-contained task the assistant can carry out and report on.
+The last step should produce the final answer for the user.`
+
+// ErrPlanUnfinished is returned by PlanAndExecute when the step limit is
+// reached with steps still pending.  The plan is kept in the session, so a
+// later call resumes it.
+var ErrPlanUnfinished = errors.New("plan unfinished")
+
+// StepStatus is the progress of a plan step.
+type StepStatus string
+
+const (
+	StepPending StepStatus = "pending"
+	StepDone    StepStatus = "done"
+	StepFailed  StepStatus = "failed"
+)
+
+// PlanStep is one step of a Plan.
+type PlanStep struct {
+	Description string     `json:"description"`
+	Status      StepStatus `json:"status"`
+	Result      string     `json:"result,omitempty"` // the executor's answer, or the error of a failed step
+}
+
+// Plan is an explicit list of steps towards a goal, with their progress.
+type Plan struct {
+	Goal  string     `json:"goal"`
+	Steps []PlanStep `json:"steps"`
+	// Turn counts the session's user messages when the plan was made, so
+	// a later request can be told apart from the goal itself.
+	Turn int `json:"turn,omitempty"`
+}
+
+// Next returns the index of the first pending step, or -1 if there is none.
+func (p Plan) Next() int {
+	for i, s := range p.Steps {
+		if s.Status == StepPending {
+			return i
+		}
+	}
+	return -1
+}
+
+// Markdown renders the plan as a numbered checklist, with the result of
+// each finished step quoted beneath it.
+func (p Plan) Markdown() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**Goal:** %s\n\n", p.Goal)
+	for i, s := range p.Steps {
+		box := "[ ]"
+		switch s.Status {
+		case StepDone:
+			box = "[x]"
+		case StepFailed:
+			box = "[!]"
+		}
+		fmt.Fprintf(&sb, "%d. %s %s\n", i+1, box, s.Description)
+		if s.Result != "" {
+			for _, line := range strings.Split(s.Result, "\n") {
+				fmt.Fprintf(&sb, "   > %s\n", line)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// PlanFromSession returns the plan stored in s by PlanAndExecute.
+func PlanFromSession(s Session) (Plan, bool, error) {
+	var p Plan
+	ok, err := s.GetState(PlanStateKey, &p)
+	return p, ok, err
+}
+
+// PlanOption configures PlanAndExecute.
+type PlanOption func(*planConfig)
+
+type planConfig struct {
+	replan        bool
+	maxSteps      int
+	plannerPrompt string
+	onUpdate      func(Plan)
+}
+
+// WithReplanning asks the planner to revise the remaining steps after
+// every step, and after a failed step instead of stopping.  Without it a
+// failed step stops PlanAndExecute and is retried when the session is
+// resumed.
+func WithReplanning() PlanOption {
+	return func(c *planConfig) { c.replan = true }
+}
+
+// WithMaxSteps sets how many steps are executed before PlanAndExecute gives
+// up with ErrPlanUnfinished (default DefaultMaxPlanSteps).
+func WithMaxSteps(n int) PlanOption {
+	return func(c *planConfig) { c.maxSteps = n }
+}
+
+// WithPlannerPrompt replaces DefaultPlannerPrompt.
+func WithPlannerPrompt(prompt string) PlanOption {
+	return func(c *planConfig) { c.plannerPrompt = prompt }
+}
+
+// WithPlanListener sets a function called with the plan whenever it is
+// created or changes.
+func WithPlanListener(fn func(Plan)) PlanOption {
+	return func(c *planConfig) { c.onUpdate = fn }
+}
+
+// planSteps is the planner's structured answer.
+type planSteps struct {
+	Steps []string `json:"steps" description:"The steps still to do, in order. Empty if the goal has been achieved."`
+}
+
+// PlanAndExecute works towards the goal in the session's last user message
+// in three parts: planner submits a list of steps, executor runs each step
+// in its own AgentLoop, and, with WithReplanning, planner revises the
+// remaining steps after each result.  The plan and its progress are kept in
+// the session's State (see PlanFromSession) and updated after every step,
+// so a session holding an unfinished plan resumes it, retrying a step that
+// failed unless WithReplanning is set.  When the plan is complete the last
+// step's result is added to the session as the assistant's reply.  A
+// finished plan is only replaced once a new user message follows it;
+// until then the session is returned unchanged.
+//
+// The planner sees only the goal and the plan, and each step sees the goal,
+// the plan with earlier results and its own task, which keeps every call
+// focused however long the plan runs.
+func PlanAndExecute(ctx context.Context, planner InvokeModelFunc, executor Agent, session Session, opts ...PlanOption) (Session, error) {
+	cfg := &planConfig{maxSteps: DefaultMaxPlanSteps, plannerPrompt: DefaultPlannerPrompt}
+	for _, o := range opts {
+		o(cfg)
+	}
+	save := func(p Plan) error {
+		if cfg.onUpdate != nil {
+			cfg.onUpdate(p)
+		}
+		return session.SetState(PlanStateKey, p)
+	}
+
+	plan, ok, err := PlanFromSession(session)
+	if err != nil {
+		return session, err
+	}
+	if ok && !cfg.replan {
+		// Nothing revised the failed step when it stopped the last call,
+		// so try it again rather than skipping to the next one.
+		for i, s := range plan.Steps {
+			if s.Status == StepFailed {
+				plan.Steps[i] = PlanStep{Description: s.Description, Status: StepPending}
+			}
+		}
+	}
+	goal, turn := "", 0
+	for _, m := range MessagesOf[UserMessage](session) {
+		goal, turn = m.Content, turn+1
+	}
+	if ok && plan.Next() < 0 && goal == plan.Goal && turn == plan.Turn {
+		// Already finished, and there is no new request to plan for.
+		return session, plan.failure()
+	}
+	if !ok || plan.Next() < 0 {
+		if goal == "" {
+			return session, errors.New("plan: session has no user message to plan for")
+		}
+		plan = Plan{Goal: goal, Turn: turn}
+		steps, err := cfg.plan(ctx, planner, fmt.Sprintf("Goal:\n%s\n\nSubmit a plan to achieve the goal.", goal))
+		if err != nil {
+			return session, fmt.Errorf("plan: %w", err)
+		}
+		if len(steps) == 0 {
+			return session, errors.New("plan: planner submitted no steps")
+		}
+		plan.Steps = pendingSteps(steps)
+		if err := save(plan); err != nil {
+			return session, err
+		}
+	}
+
+	execCtx := executor.budget(ctx)
+	for executed := 0; ; executed++ {
+		i := plan.Next()
+		if i < 0 {
+			break
+		}
+		if executed == cfg.maxSteps {
+			return session, fmt.Errorf("%w after %d steps", ErrPlanUnfinished, executed)
+		}
+
+		prompt := fmt.Sprintf("Goal:\n%s\n\nPlan:\n%s\nCarry out step %d: %s\nReply with the outcome of this step.",
+			plan.Goal, plan.Markdown(), i+1, plan.Steps[i].Description)
+		sub, err := executor.run(execCtx, prompt)
+		out := ""
+		if err == nil {
+			out, err = ResultLastText(sub)
+		}
+		if err != nil {
+			plan.Steps[i].Status, plan.Steps[i].Result = StepFailed, err.Error()
+		} else {
+			plan.Steps[i].Status, plan.Steps[i].Result = StepDone, out
+		}
+		if err := save(plan); err != nil {
+			return session, err
+		}
+		if ctx.Err() != nil {
+			return session, ctx.Err()
+		}
+		if err != nil && !cfg.replan {
+			return session, fmt.Errorf("plan step %d: %w", i+1, err)
+		}
+
+		if cfg.replan && (err != nil || plan.Next() >= 0) {
+			steps, err := cfg.plan(ctx, planner, fmt.Sprintf(
+				"Goal:\n%s\n\nPlan so far:\n%s\nRevise the steps still to do in light of the results so far and submit them.",
+				plan.Goal, plan.Markdown()))
+			if err != nil {
+				return session, fmt.Errorf("replan: %w", err)
+			}
+			var kept []PlanStep
+			for _, s := range plan.Steps {
+				if s.Status != StepPending {
+					kept = append(kept, s)
+				}
+			}
+			plan.Steps = append(kept, pendingSteps(steps)...)
+			if err := save(plan); err != nil {
+				return session, err
+			}
+		}
+	}
+
+	if err := plan.failure(); err != nil {
+		return session, err
+	}
+	session.Add(AssistantMessage{plan.Steps[len(plan.Steps)-1].Result})
+	return session, nil
+}
+
+// failure returns the error of a finished plan whose last step failed.
+func (p Plan) failure() error {
+	if n := len(p.Steps); n > 0 && p.Steps[n-1].Status == StepFailed {
+		return fmt.Errorf("plan step %d: %s", n, p.Steps[n-1].Result)
+	}
+	return nil
+}
+
+// plan asks planner for a list of steps.
+func (c *planConfig) plan(ctx context.Context, planner InvokeModelFunc, prompt string) ([]string, error) {
+	out, _, err := AgentLoopAnswer[planSteps](ctx, planner, nil, InitSession(c.plannerPrompt, prompt))
+	return out.Steps, err
+}
+
+func pendingSteps(descs []string) []PlanStep {
+	steps := make([]PlanStep, len(descs))
+	for i, d := range descs {
+		steps[i] = PlanStep{Description: d, Status: StepPending}
+	}
+	return steps
+}
@@ -0,0 +1,188 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// plannerModel returns a model that submits the next step list from plans on
+// each call and records the prompts it was given.
+func plannerModel(prompts *[]string, plans ...[]string) InvokeModelFunc {
+	n := 0
+	return func(_ context.Context, _ []ToolDefinition, s Session) ([]Message, Usage, error) {
+		*prompts = append(*prompts, s.Messages[1].(UserMessage).Content)
+		input, _ := json.Marshal(map[string]any{"steps": plans[n]})
+		n++
+		return []Message{submit(fmt.Sprint("p", n), string(input))}, Usage{}, nil
+	}
+}
+
+// stepAgent carries out a step by naming it, failing steps called "flaky".
+var stepAgent = Agent{Name: "executor", SystemPrompt: "Do it.", Model: func(_ context.Context, _ []ToolDefinition, s Session) ([]Message, Usage, error) {
+	prompt := s.Messages[1].(UserMessage).Content
+	_, step, _ := strings.Cut(prompt, "Carry out step ")
+	step, _, _ = strings.Cut(step, "\n")
+	if strings.HasSuffix(step, "flaky") {
+		return nil, Usage{}, errors.New("timeout")
+	}
+	return []Message{AssistantMessage{"did " + step}}, Usage{}, nil
+}}
+
+func stepStatuses(t *testing.T, s Session) []string {
+	t.Helper()
+	p, ok, err := PlanFromSession(s)
+	if !ok || err != nil {
+		t.Fatalf("no plan: %v", err)
+	}
+	var out []string
+	for _, st := range p.Steps {
+		out = append(out, st.Description+":"+string(st.Status))
+	}
+	return out
+}
+
+func TestPlanAndExecute(t *testing.T) {
+	var prompts []string
+	updates := 0
+	session, err := PlanAndExecute(context.Background(), plannerModel(&prompts, []string{"fetch", "summarise"}),
+		stepAgent, InitSession("sys", "Migrate the data."), WithPlanListener(func(Plan) { updates++ }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer, _ := session.FinalAnswer(); answer != "did 2: summarise" {
+		t.Errorf("final answer %q", answer)
+	}
+	if got := stepStatuses(t, session); !reflect.DeepEqual(got, []string{"fetch:done", "summarise:done"}) {
+		t.Errorf("plan: %v", got)
+	}
+	if len(prompts) != 1 || !strings.Contains(prompts[0], "Migrate the data.") || updates != 3 {
+		t.Errorf("planner prompts %q, %d updates", prompts, updates)
+	}
+
+	md := RenderMarkdown(session)
+	if !strings.Contains(md, "## Plan\n\n**Goal:** Migrate the data.\n\n1. [x] fetch\n   > did 1: fetch\n") {
+		t.Errorf("rendered plan missing:\n%s", md)
+	}
+
+	// The plan survives serialisation.
+	data, err := json.Marshal(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Session
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got := stepStatuses(t, decoded); len(got) != 2 {
+		t.Errorf("decoded plan: %v", got)
+	}
+}
+
+func TestPlanAndExecuteReplan(t *testing.T) {
+	var prompts []string
+
+	// Without replanning a failed step stops the run, keeping the plan.
+	session, err := PlanAndExecute(context.Background(), plannerModel(&prompts, []string{"flaky", "report"}),
+		stepAgent, InitSession("sys", "Go."))
+	if err == nil || !strings.Contains(err.Error(), "plan step 1: timeout") {
+		t.Fatalf("got %v", err)
+	}
+	if got := stepStatuses(t, session); !reflect.DeepEqual(got, []string{"flaky:failed", "report:pending"}) {
+		t.Errorf("plan: %v", got)
+	}
+
+	// Resuming retries the failed step instead of skipping it.
+	var tried []string
+	recovered := stepAgent
+	recovered.Model = func(_ context.Context, _ []ToolDefinition, s Session) ([]Message, Usage, error) {
+		prompt := s.Messages[1].(UserMessage).Content
+		_, step, _ := strings.Cut(prompt, "Carry out step ")
+		step, _, _ = strings.Cut(step, "\n")
+		tried = append(tried, step)
+		return []Message{AssistantMessage{"did " + step}}, Usage{}, nil
+	}
+	resumed, err := PlanAndExecute(context.Background(), nil, recovered, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stepStatuses(t, resumed); !reflect.DeepEqual(got, []string{"flaky:done", "report:done"}) {
+		t.Errorf("resumed plan: %v", got)
+	}
+	if !reflect.DeepEqual(tried, []string{"1: flaky", "2: report"}) {
+		t.Errorf("resumed steps: %q", tried)
+	}
+
+	// With replanning the steps are revised after the failure and after
+	// each later step that leaves work pending.
+	prompts = nil
+	planner := plannerModel(&prompts, []string{"flaky", "report"}, []string{"retry", "report"}, []string{"report"})
+	session, err = PlanAndExecute(context.Background(), planner, stepAgent, InitSession("sys", "Go."), WithReplanning())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"flaky:failed", "retry:done", "report:done"}
+	if got := stepStatuses(t, session); !reflect.DeepEqual(got, want) {
+		t.Errorf("plan: %v, want %v", got, want)
+	}
+	if len(prompts) != 3 || !strings.Contains(prompts[1], "1. [!] flaky\n   > timeout") {
+		t.Errorf("planner prompts: %q", prompts)
+	}
+
+	// A step limit leaves the rest of the plan for a later call.
+	prompts = nil
+	session, err = PlanAndExecute(context.Background(), plannerModel(&prompts, []string{"a", "b"}),
+		stepAgent, InitSession("sys", "Go."), WithMaxSteps(1))
+	if !errors.Is(err, ErrPlanUnfinished) {
+		t.Fatalf("got %v", err)
+	}
+	if session, err = PlanAndExecute(context.Background(), nil, stepAgent, session); err != nil {
+		t.Fatal(err)
+	}
+	if got := stepStatuses(t, session); !reflect.DeepEqual(got, []string{"a:done", "b:done"}) {
+		t.Errorf("resumed plan: %v", got)
+	}
+}
+
+// TestPlanAndExecuteFinished checks that a finished plan is not run again
+// until a new request follows it.
+func TestPlanAndExecuteFinished(t *testing.T) {
+	var prompts []string
+	planner := plannerModel(&prompts, []string{"migrate"}, []string{"verify"})
+	steps := 0
+	executor := stepAgent
+	executor.Model = func(ctx context.Context, defs []ToolDefinition, s Session) ([]Message, Usage, error) {
+		steps++
+		return stepAgent.Model(ctx, defs, s)
+	}
+
+	session, err := PlanAndExecute(context.Background(), planner, executor, InitSession("sys", "Migrate the data."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := PlanAndExecute(context.Background(), planner, executor, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prompts) != 1 || steps != 1 || len(again.Messages) != len(session.Messages) {
+		t.Errorf("second call: %d planner calls, %d steps, %d messages (was %d)",
+			len(prompts), steps, len(again.Messages), len(session.Messages))
+	}
+
+	// A new request, even with the same text, gets a new plan.
+	again.Add(UserMessage{"Migrate the data."})
+	again, err = PlanAndExecute(context.Background(), planner, executor, again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prompts) != 2 || steps != 2 {
+		t.Errorf("new request: %d planner calls, %d steps", len(prompts), steps)
+	}
+	if answer, _ := again.FinalAnswer(); answer != "did 1: verify" {
+		t.Errorf("final answer %q", answer)
+	}
+}
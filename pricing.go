@@ -0,0 +1,100 @@
+package agentloop
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownModel is returned when a price table has no entry for a model.
+var ErrUnknownModel = errors.New("no pricing for model")
+
+// Pricing holds a model's rates in US dollars per million tokens.
+// CacheWrite is the five-minute cache write rate; one-hour writes cost more
+// and should be priced with an overridden table if they dominate.
+type Pricing struct {
+	Input      float64
+	Output     float64
+	CacheWrite float64
+	CacheRead  float64
+}
+
+// Cost returns the dollar cost of u at these rates.  Its signature suits
+// callbacks that price usage, such as eval.WithCostFunc.
+func (p Pricing) Cost(u Usage) float64 {
+	return (float64(u.InputTokens)*p.Input +
+		float64(u.OutputTokens)*p.Output +
+		float64(u.CacheCreationInputTokens)*p.CacheWrite +
+		float64(u.CacheReadInputTokens)*p.CacheRead) / 1e6
+}
+
+// PriceTable maps model names, or model name prefixes, to their pricing.
+type PriceTable map[string]Pricing
+
+// DefaultPrices holds Anthropic's published list prices.  Entries are
+// prefixes, so dated snapshots and -latest aliases resolve to their family.
+// Prices change; override entries with PriceTable.With rather than relying
+// on these for billing.
+var DefaultPrices = PriceTable{
+	"claude-opus-4-6":          {Input: 5, Output: 25, CacheWrite: 6.25, CacheRead: 0.50},
+	"claude-opus-4-5":          {Input: 5, Output: 25, CacheWrite: 6.25, CacheRead: 0.50},
+	"claude-opus-4-1":          {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+	"claude-opus-4-0":          {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+	"claude-opus-4-20250514":   {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+	"claude-4-opus":            {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+	"claude-3-opus":            {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+	"claude-sonnet-4-6":        {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-sonnet-4-5":        {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-sonnet-4-0":        {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-sonnet-4-20250514": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-4-sonnet":          {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-3-7-sonnet":        {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-haiku-4-5":         {Input: 1, Output: 5, CacheWrite: 1.25, CacheRead: 0.10},
+	"claude-3-5-haiku":         {Input: 0.80, Output: 4, CacheWrite: 1, CacheRead: 0.08},
+	"claude-3-haiku":           {Input: 0.25, Output: 1.25, CacheWrite: 0.30, CacheRead: 0.03},
+}
+
+// Lookup returns the pricing for model: an exact entry if there is one,
+// otherwise the entry with the longest key that prefixes model.
+func (t PriceTable) Lookup(model string) (Pricing, bool) {
+	if p, ok := t[model]; ok {
+		return p, true
+	}
+	best, found := "", false
+	for k := range t {
+		if strings.HasPrefix(model, k) && len(k) > len(best) {
+			best, found = k, true
+		}
+	}
+	return t[best], found
+}
+
+// With returns a copy of t with the entries of overrides added or
+// replacing existing ones.
+func (t PriceTable) With(overrides PriceTable) PriceTable {
+	out := make(PriceTable, len(t)+len(overrides))
+	for k, v := range t {
+		out[k] = v
+	}
+	for k, v := range overrides {
+		out[k] = v
+	}
+	return out
+}
+
+// Cost returns the dollar cost of u on model.
+func (t PriceTable) Cost(model string, u Usage) (float64, error) {
+	p, ok := t.Lookup(model)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrUnknownModel, model)
+	}
+	return p.Cost(u), nil
+}
+
+// DollarBudget returns a UsageFunc for WithUsageChecker that halts the loop
+// once the cumulative cost at pricing p reaches budget dollars.  Because
+// the check runs before each model call, the final call can overshoot the
+// budget by up to one response.
+func DollarBudget(p Pricing, budget float64) UsageFunc {
+	return func(u Usage) bool { return p.Cost(u) >= budget }
+}
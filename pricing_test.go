@@ -0,0 +1,71 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	anthropic "github.com/anthropics/anthropic-sdk-go"
+)
+
+func TestPricingCost(t *testing.T) {
+	p := Pricing{Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30}
+	u := Usage{InputTokens: 1_000_000, OutputTokens: 100_000, CacheCreationInputTokens: 200_000, CacheReadInputTokens: 1_000_000}
+	if got, want := p.Cost(u), 3+1.5+0.75+0.30; math.Abs(got-want) > 1e-9 {
+		t.Errorf("Cost = %v, want %v", got, want)
+	}
+}
+
+func TestPriceTableLookup(t *testing.T) {
+	tests := []struct {
+		model string
+		input float64
+	}{
+		{string(anthropic.ModelClaudeSonnet4_6), 3},
+		{string(anthropic.ModelClaudeOpus4_5_20251101), 5},
+		{string(anthropic.ModelClaudeOpus4_1_20250805), 15},
+		{string(anthropic.ModelClaudeHaiku4_5_20251001), 1},
+		{string(anthropic.ModelClaude3_5HaikuLatest), 0.80},
+		{string(anthropic.ModelClaudeSonnet4_20250514), 3},
+	}
+	for _, tt := range tests {
+		p, ok := DefaultPrices.Lookup(tt.model)
+		if !ok || p.Input != tt.input {
+			t.Errorf("%s: got %+v, %v; want input rate %v", tt.model, p, ok, tt.input)
+		}
+	}
+
+	if _, err := DefaultPrices.Cost("gpt-4", Usage{}); !errors.Is(err, ErrUnknownModel) {
+		t.Errorf("got %v, want ErrUnknownModel", err)
+	}
+
+	custom := DefaultPrices.With(PriceTable{"claude-sonnet-4-6": {Input: 1}})
+	if p, _ := custom.Lookup("claude-sonnet-4-6"); p.Input != 1 {
+		t.Errorf("override not applied: %+v", p)
+	}
+	if p, _ := DefaultPrices.Lookup("claude-sonnet-4-6"); p.Input != 3 {
+		t.Errorf("With modified the original table: %+v", p)
+	}
+}
+
+// TestDollarBudget runs a loop that spends $0.30 per call against a $1
+// budget and checks that it stops after the fourth call.
+func TestDollarBudget(t *testing.T) {
+	calls := 0
+	invoker := func(context.Context, []ToolDefinition, Session) ([]Message, Usage, error) {
+		calls++
+		return []Message{ToolCallMessage{ID: fmt.Sprintf("c%d", calls), Name: "noop", Input: json.RawMessage(`{}`)}},
+			Usage{InputTokens: 100_000}, nil
+	}
+	_, err := AgentLoop(context.Background(), invoker, []Tool{noopTool}, InitSession("sys", "u"),
+		WithUsageChecker(DollarBudget(Pricing{Input: 3}, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 4 {
+		t.Errorf("model called %d times, want 4", calls)
+	}
+}
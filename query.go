@@ -0,0 +1,281 @@
+package agentloop
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Message kind names, as returned by KindOf and accepted by Query.OfKind.
+const (
+	KindSystem     = "system"
+	KindUser       = "user"
+	KindAssistant  = "assistant"
+	KindThinking   = "thinking"
+	KindToolCall   = "tool_call"
+	KindToolResult = "tool_result"
+	KindOpaque     = "opaque"
+)
+
+// KindOf returns the kind name of a message (one of the Kind constants).
+func KindOf(m Message) string { return m.messageKind() }
+
+// MessagesOf returns every message of concrete type T in session order.
+//
+//	for _, tc := range MessagesOf[ToolCallMessage](session) { ... }
+func MessagesOf[T Message](s Session) []T {
+	var out []T
+	for _, msg := range s.Messages {
+		if m, ok := msg.(T); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// messageText returns the searchable text of a message: its content, tool
+// output, or tool name and input.
+func messageText(m Message) string {
+	switch m := m.(type) {
+	case SystemMessage:
+		return m.Content
+	case UserMessage:
+		return m.Content
+	case AssistantMessage:
+		return m.Content
+	case ThinkingMessage:
+		return m.Content
+	case ToolCallMessage:
+		return m.Name + " " + string(m.Input)
+	case ToolResultMessage:
+		return m.Output
+	case OpaqueMessage:
+		return string(m.Raw)
+	default:
+		return ""
+	}
+}
+
+// IsErrorResult reports whether a tool result records a failure.  Results
+// produced by ExecuteToolCalls for handler errors and unknown tools (and
+// those synthesized by Repair) begin with "Error:".
+func IsErrorResult(r ToolResultMessage) bool {
+	return strings.HasPrefix(r.Output, "Error:")
+}
+
+// Query is an immutable, filtered view over a session's messages.  Each
+// filter method returns a new Query; the underlying session is not copied
+// or modified.
+type Query struct {
+	s   Session
+	idx []int
+}
+
+// NewQuery returns a Query matching every message in s.
+func NewQuery(s Session) Query {
+	idx := make([]int, len(s.Messages))
+	for i := range idx {
+		idx[i] = i
+	}
+	return Query{s: s, idx: idx}
+}
+
+// Where keeps messages for which keep returns true.
+func (q Query) Where(keep func(Message) bool) Query {
+	var idx []int
+	for _, i := range q.idx {
+		if keep(q.s.Messages[i]) {
+			idx = append(idx, i)
+		}
+	}
+	return Query{s: q.s, idx: idx}
+}
+
+// OfKind keeps messages whose kind is one of kinds.
+func (q Query) OfKind(kinds ...string) Query {
+	return q.Where(func(m Message) bool {
+		for _, k := range kinds {
+			if m.messageKind() == k {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Tool keeps tool calls to any of the named tools and the results that
+// answer them.
+func (q Query) Tool(names ...string) Query {
+	ids := make(map[string]bool)
+	for _, m := range q.s.Messages {
+		if tc, ok := m.(ToolCallMessage); ok {
+			for _, n := range names {
+				if tc.Name == n {
+					ids[tc.ID] = true
+				}
+			}
+		}
+	}
+	return q.Where(func(m Message) bool {
+		switch m := m.(type) {
+		case ToolCallMessage:
+			return ids[m.ID]
+		case ToolResultMessage:
+			return ids[m.ID]
+		default:
+			return false
+		}
+	})
+}
+
+// Range keeps messages at positions from (inclusive) to to (exclusive) in
+// the session.  Use Since and Until to filter by time.
+func (q Query) Range(from, to int) Query {
+	var idx []int
+	for _, i := range q.idx {
+		if i >= from && i < to {
+			idx = append(idx, i)
+		}
+	}
+	return Query{s: q.s, idx: idx}
+}
+
+// Since keeps messages added at or after t (see Session.Times).  Messages
+// with no recorded time are dropped.
+func (q Query) Since(t time.Time) Query {
+	return q.timed(func(at time.Time) bool { return !at.Before(t) })
+}
+
+// Until keeps messages added before t.  Messages with no recorded time are
+// dropped.
+func (q Query) Until(t time.Time) Query {
+	return q.timed(func(at time.Time) bool { return at.Before(t) })
+}
+
+func (q Query) timed(keep func(time.Time) bool) Query {
+	var idx []int
+	for _, i := range q.idx {
+		if at := q.s.Time(i); !at.IsZero() && keep(at) {
+			idx = append(idx, i)
+		}
+	}
+	return Query{s: q.s, idx: idx}
+}
+
+// Contains keeps messages whose text contains substr, ignoring case.
+func (q Query) Contains(substr string) Query {
+	substr = strings.ToLower(substr)
+	return q.Where(func(m Message) bool {
+		return strings.Contains(strings.ToLower(messageText(m)), substr)
+	})
+}
+
+// Match keeps messages whose text matches re.
+func (q Query) Match(re *regexp.Regexp) Query {
+	return q.Where(func(m Message) bool { return re.MatchString(messageText(m)) })
+}
+
+// Messages returns the matching messages in session order.
+func (q Query) Messages() []Message {
+	out := make([]Message, len(q.idx))
+	for j, i := range q.idx {
+		out[j] = q.s.Messages[i]
+	}
+	return out
+}
+
+// Indices returns the session positions of the matching messages.
+func (q Query) Indices() []int {
+	return append([]int(nil), q.idx...)
+}
+
+// Count returns the number of matching messages.
+func (q Query) Count() int { return len(q.idx) }
+
+// First returns the earliest matching message.
+func (q Query) First() (Message, bool) {
+	if len(q.idx) == 0 {
+		return nil, false
+	}
+	return q.s.Messages[q.idx[0]], true
+}
+
+// Last returns the latest matching message.
+func (q Query) Last() (Message, bool) {
+	if len(q.idx) == 0 {
+		return nil, false
+	}
+	return q.s.Messages[q.idx[len(q.idx)-1]], true
+}
+
+// ToolExchange pairs a tool call with the result that answered it.
+type ToolExchange struct {
+	Call        ToolCallMessage
+	CallIndex   int
+	Result      *ToolResultMessage // nil if the call was never answered
+	ResultIndex int                // -1 if Result is nil
+}
+
+// ToolExchanges pairs every tool call in the session with its first result,
+// in call order.
+func (s Session) ToolExchanges() []ToolExchange {
+	var out []ToolExchange
+	pos := make(map[string]int)
+	for i, msg := range s.Messages {
+		switch m := msg.(type) {
+		case ToolCallMessage:
+			pos[m.ID] = len(out)
+			out = append(out, ToolExchange{Call: m, CallIndex: i, ResultIndex: -1})
+		case ToolResultMessage:
+			if j, ok := pos[m.ID]; ok && out[j].Result == nil {
+				out[j].Result = &m
+				out[j].ResultIndex = i
+			}
+		}
+	}
+	return out
+}
+
+// FinalAnswer returns the content of the last AssistantMessage in the
+// session, or false if the model never replied with text.
+func (s Session) FinalAnswer() (string, bool) {
+	for i := len(s.Messages) - 1; i >= 0; i-- {
+		if am, ok := s.Messages[i].(AssistantMessage); ok {
+			return am.Content, true
+		}
+	}
+	return "", false
+}
+
+// ToolStats summarises the calls made to a single tool.
+type ToolStats struct {
+	Calls      int // tool calls issued
+	Errors     int // results for which IsErrorResult is true
+	Unanswered int // calls with no result in the session
+}
+
+// ErrorRate returns the fraction of answered calls that failed.
+func (t ToolStats) ErrorRate() float64 {
+	answered := t.Calls - t.Unanswered
+	if answered == 0 {
+		return 0
+	}
+	return float64(t.Errors) / float64(answered)
+}
+
+// ToolStats returns per-tool call counts and error figures keyed by tool name.
+func (s Session) ToolStats() map[string]ToolStats {
+	stats := make(map[string]ToolStats)
+	for _, ex := range s.ToolExchanges() {
+		st := stats[ex.Call.Name]
+		st.Calls++
+		switch {
+		case ex.Result == nil:
+			st.Unanswered++
+		case IsErrorResult(*ex.Result):
+			st.Errors++
+		}
+		stats[ex.Call.Name] = st
+	}
+	return stats
+}
@@ -0,0 +1,152 @@
+package agentloop
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// querySession is a small transcript shared by the query tests.
+//
+//	[0] SystemMessage
+//	[1] UserMessage
+//	[2] ThinkingMessage
+//	[3] ToolCallMessage   add c1
+//	[4] ToolCallMessage   lookup c2
+//	[5] ToolResultMessage c1 "3"
+//	[6] ToolResultMessage c2 error
+//	[7] ToolCallMessage   add c3 (unanswered)
+//	[8] AssistantMessage  "The answer is 3."
+func querySession() Session {
+	s := InitSession("You are a calculator.", "What is 1 + 2?")
+	s.Add(
+		ThinkingMessage{"I should add."},
+		ToolCallMessage{ID: "c1", Name: "add", Input: json.RawMessage(`{"a":1,"b":2}`)},
+		ToolCallMessage{ID: "c2", Name: "lookup", Input: json.RawMessage(`{"q":"pi"}`)},
+		ToolResultMessage{ID: "c1", Output: "3"},
+		ToolResultMessage{ID: "c2", Output: "Error: not found"},
+		ToolCallMessage{ID: "c3", Name: "add", Input: json.RawMessage(`{"a":0,"b":0}`)},
+		AssistantMessage{"The answer is 3."},
+	)
+	return s
+}
+
+func TestQueryFilters(t *testing.T) {
+	s := querySession()
+
+	tests := []struct {
+		name string
+		q    Query
+		want []int
+	}{
+		{"all", NewQuery(s), []int{0, 1, 2, 3, 4, 5, 6, 7, 8}},
+		{"kind", NewQuery(s).OfKind(KindToolCall), []int{3, 4, 7}},
+		{"kinds", NewQuery(s).OfKind(KindUser, KindAssistant), []int{1, 8}},
+		{"tool", NewQuery(s).Tool("add"), []int{3, 5, 7}},
+		{"range", NewQuery(s).Range(2, 5), []int{2, 3, 4}},
+		{"contains", NewQuery(s).Contains("ANSWER"), []int{8}},
+		{"match", NewQuery(s).Match(regexp.MustCompile(`^Error:`)), []int{6}},
+		{"chained", NewQuery(s).Tool("add").OfKind(KindToolResult), []int{5}},
+		{"none", NewQuery(s).Contains("zebra"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Indices(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if tt.q.Count() != len(tt.want) {
+				t.Errorf("Count() = %d, want %d", tt.q.Count(), len(tt.want))
+			}
+		})
+	}
+
+	first, _ := NewQuery(s).OfKind(KindToolCall).First()
+	last, _ := NewQuery(s).OfKind(KindToolCall).Last()
+	if first.(ToolCallMessage).ID != "c1" || last.(ToolCallMessage).ID != "c3" {
+		t.Errorf("First/Last: got %v, %v", first, last)
+	}
+	if _, ok := NewQuery(s).Contains("zebra").First(); ok {
+		t.Error("First on empty query returned ok")
+	}
+}
+
+func TestQueryTimeRange(t *testing.T) {
+	s := querySession()
+	start := time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC)
+	for i := range s.Times {
+		s.Times[i] = start.Add(time.Duration(i) * time.Minute)
+	}
+	s.Times[4] = time.Time{} // unknown, so never in a time range
+
+	at := func(min int) time.Time { return start.Add(time.Duration(min) * time.Minute) }
+	tests := []struct {
+		name string
+		q    Query
+		want []int
+	}{
+		{"since", NewQuery(s).Since(at(6)), []int{6, 7, 8}},
+		{"until", NewQuery(s).Until(at(2)), []int{0, 1}},
+		{"window", NewQuery(s).Since(at(3)).Until(at(6)), []int{3, 5}},
+		{"chained", NewQuery(s).OfKind(KindToolCall).Since(at(1)), []int{3, 7}},
+	}
+	for _, tt := range tests {
+		if got := tt.q.Indices(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Messages added without a time, as in older saved sessions, match no
+	// time range.
+	untimed := Session{Messages: s.Messages}
+	if n := NewQuery(untimed).Since(time.Time{}).Count(); n != 0 {
+		t.Errorf("untimed session: %d matches", n)
+	}
+}
+
+func TestMessagesOf(t *testing.T) {
+	calls := MessagesOf[ToolCallMessage](querySession())
+	if len(calls) != 3 || calls[1].Name != "lookup" {
+		t.Errorf("got %v", calls)
+	}
+}
+
+func TestToolExchanges(t *testing.T) {
+	ex := querySession().ToolExchanges()
+	if len(ex) != 3 {
+		t.Fatalf("got %d exchanges, want 3", len(ex))
+	}
+	if ex[0].Result == nil || ex[0].Result.Output != "3" || ex[0].CallIndex != 3 || ex[0].ResultIndex != 5 {
+		t.Errorf("exchange 0: %+v", ex[0])
+	}
+	if ex[2].Result != nil || ex[2].ResultIndex != -1 {
+		t.Errorf("exchange 2 should be unanswered: %+v", ex[2])
+	}
+}
+
+func TestFinalAnswer(t *testing.T) {
+	if got, ok := querySession().FinalAnswer(); !ok || got != "The answer is 3." {
+		t.Errorf("got %q, %v", got, ok)
+	}
+	if _, ok := InitSession("sys", "user").FinalAnswer(); ok {
+		t.Error("expected no final answer")
+	}
+}
+
+func TestToolStats(t *testing.T) {
+	got := querySession().ToolStats()
+	want := map[string]ToolStats{
+		"add":    {Calls: 2, Unanswered: 1},
+		"lookup": {Calls: 1, Errors: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if r := got["lookup"].ErrorRate(); r != 1 {
+		t.Errorf("lookup error rate: got %v, want 1", r)
+	}
+	if r := got["add"].ErrorRate(); r != 0 {
+		t.Errorf("add error rate: got %v, want 0", r)
+	}
+}
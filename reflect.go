@@ -0,0 +1,67 @@
+package agentloop
+
+import (
+	"context"
+	"fmt"
+)
+
+// DefaultCriticPrompt is the system prompt of a Critic created with an
+// empty prompt.
+const DefaultCriticPrompt = `You review an AI assistant's work before it is returned to the user.
+Check the assistant's final answer, at the end of the transcript, against the user's request and the tool results: is it correct, complete and supported by the evidence?
+Approve it unless there is a concrete problem worth another attempt. If there is, explain exactly what is wrong and what to fix.`
+
+// Critique is a critic's verdict on an agent's final answer.
+type Critique struct {
+	Approved bool   `json:"approved" description:"Whether the answer can be returned as it is."`
+	Feedback string `json:"feedback,omitempty" description:"What is wrong with the answer and how to fix it. Required unless approved."`
+}
+
+// CriticFunc reviews a session whose last message is the agent's final
+// answer.
+type CriticFunc func(ctx context.Context, s Session) (Critique, error)
+
+// Critic returns a CriticFunc that asks model, with the given system prompt
+// (DefaultCriticPrompt if empty), to review a Markdown rendering of the
+// session and submit a Critique.
+func Critic(model InvokeModelFunc, prompt string) CriticFunc {
+	if prompt == "" {
+		prompt = DefaultCriticPrompt
+	}
+	return func(ctx context.Context, s Session) (Critique, error) {
+		review := "Review the final answer at the end of this transcript.\n\n" + RenderMarkdown(s)
+		c, _, err := AgentLoopAnswer[Critique](ctx, model, nil, InitSession(prompt, review))
+		return c, err
+	}
+}
+
+// WithReflection makes the loop show its final answer to critic before
+// stopping.  If the critic does not approve, its feedback is added as a
+// UserMessage and the loop continues, for up to rounds rejections; after
+// that, or on the last iteration, the answer stands.  In a structured-answer
+// loop the critic reviews each accepted submission, and a rejected one is
+// discarded so the model must submit again.
+func WithReflection(critic CriticFunc, rounds int) AgentLoopOption {
+	return func(c *agentLoopConfig) { c.reflection = &reflection{critic: critic, rounds: rounds} }
+}
+
+type reflection struct {
+	critic CriticFunc
+	rounds int
+}
+
+// review runs the critic and returns the feedback message to continue
+// with, or false if the answer stands.
+func (r *reflection) review(ctx context.Context, s Session, round int) (UserMessage, bool, error) {
+	if round >= r.rounds {
+		return UserMessage{}, false, nil
+	}
+	c, err := r.critic(ctx, s)
+	if err != nil {
+		return UserMessage{}, false, fmt.Errorf("critic: %w", err)
+	}
+	if c.Approved {
+		return UserMessage{}, false, nil
+	}
+	return UserMessage{fmt.Sprintf("A reviewer found problems with your answer:\n\n%s\n\nAddress them and give your revised answer.", c.Feedback)}, true, nil
+}
@@ -0,0 +1,88 @@
+package agentloop
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestReflection(t *testing.T) {
+	model, _ := scriptedModel([]Message{AssistantMessage{"2+2=5"}}, []Message{AssistantMessage{"2+2=4"}})
+	var reviewed []string
+	critic := func(_ context.Context, s Session) (Critique, error) {
+		answer, _ := s.FinalAnswer()
+		reviewed = append(reviewed, answer)
+		if answer == "2+2=4" {
+			return Critique{Approved: true}, nil
+		}
+		return Critique{Feedback: "The sum is wrong."}, nil
+	}
+
+	session, err := AgentLoop(context.Background(), model, nil, InitSession("sys", "Add 2 and 2."),
+		WithReflection(critic, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer, _ := session.FinalAnswer(); answer != "2+2=4" || len(reviewed) != 2 {
+		t.Errorf("answer %q after reviews %q", answer, reviewed)
+	}
+	if fb, ok := session.Messages[3].(UserMessage); !ok || !strings.Contains(fb.Content, "The sum is wrong.") {
+		t.Errorf("feedback: %#v", session.Messages[3])
+	}
+
+	// Once the rounds are used up the last answer stands.
+	never := func(context.Context, Session) (Critique, error) { return Critique{Feedback: "No."}, nil }
+	model, _ = scriptedModel([]Message{AssistantMessage{"a"}}, []Message{AssistantMessage{"b"}})
+	session, err = AgentLoop(context.Background(), model, nil, InitSession("sys", "u"), WithReflection(never, 1))
+	if answer, _ := session.FinalAnswer(); err != nil || answer != "b" || len(session.Messages) != 5 {
+		t.Errorf("got %q, %v with %d messages", answer, err, len(session.Messages))
+	}
+}
+
+// TestReflectionWithAnswer checks that a structured answer is reviewed
+// before it is accepted, and a rejected one must be submitted again.
+func TestReflectionWithAnswer(t *testing.T) {
+	model, _ := scriptedModel(
+		[]Message{submit("a1", `{"grade":"low","score":2}`)},
+		[]Message{submit("a2", `{"grade":"high","score":9}`)},
+	)
+	reviews := 0
+	critic := func(_ context.Context, s Session) (Critique, error) {
+		reviews++
+		last, _ := NewQuery(s).OfKind(KindToolCall).Last()
+		if strings.Contains(string(last.(ToolCallMessage).Input), `"high"`) {
+			return Critique{Approved: true}, nil
+		}
+		return Critique{Feedback: "Too harsh."}, nil
+	}
+	got, session, err := AgentLoopAnswer[gradeAnswer](context.Background(), model, nil,
+		InitSession("sys", "Grade this."), WithReflection(critic, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Grade != "high" || reviews != 2 {
+		t.Errorf("got %+v after %d reviews", got, reviews)
+	}
+	fb := MessagesOf[UserMessage](session)
+	if len(fb) != 2 || !strings.Contains(fb[1].Content, "Too harsh.") || !strings.Contains(fb[1].Content, SubmitAnswerTool) {
+		t.Errorf("feedback: %+v", fb)
+	}
+}
+
+func TestCritic(t *testing.T) {
+	var prompt string
+	model := func(_ context.Context, _ []ToolDefinition, s Session) ([]Message, Usage, error) {
+		prompt = s.Messages[1].(UserMessage).Content
+		return []Message{submit("c1", `{"approved":false,"feedback":"Cite a source."}`)}, Usage{}, nil
+	}
+	s := InitSession("sys", "Who won?")
+	s.Add(AssistantMessage{"Team A."})
+
+	c, err := Critic(model, "")(context.Background(), s)
+	if err != nil || c.Approved || c.Feedback != "Cite a source." {
+		t.Errorf("got %+v, %v", c, err)
+	}
+	if !strings.Contains(prompt, "## User\n\nWho won?") || !strings.Contains(prompt, "## Assistant\n\nTeam A.") {
+		t.Errorf("critic prompt:\n%s", prompt)
+	}
+}
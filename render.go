@@ -0,0 +1,308 @@
+package agentloop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// RenderOption configures RenderMarkdown and RenderHTML.
+type RenderOption func(*renderConfig)
+
+// RedactFunc rewrites text before it is rendered, e.g. to mask secrets or
+// personal data.  It is applied to every message body, tool input and tool
+// output.
+type RedactFunc func(string) string
+
+type renderConfig struct {
+	title      string
+	redact     RedactFunc
+	totalUsage *Usage
+	usageAt    map[int]Usage
+}
+
+// WithTitle sets the transcript heading (default "Transcript").
+func WithTitle(title string) RenderOption {
+	return func(c *renderConfig) { c.title = title }
+}
+
+// WithRedactor sets a function applied to all rendered text.
+func WithRedactor(fn RedactFunc) RenderOption {
+	return func(c *renderConfig) { c.redact = fn }
+}
+
+// WithTotalUsage annotates the transcript header with overall token usage,
+// e.g. the cumulative figures reported to a UsageFunc.
+func WithTotalUsage(u Usage) RenderOption {
+	return func(c *renderConfig) { c.totalUsage = &u }
+}
+
+// WithMessageUsage annotates individual messages with usage figures, keyed
+// by position in Session.Messages.  Typically the key is the index of the
+// last message produced by a model invocation.
+func WithMessageUsage(usage map[int]Usage) RenderOption {
+	return func(c *renderConfig) { c.usageAt = usage }
+}
+
+// renderBlock is the renderer-neutral form of one message, shared by the
+// Markdown and HTML renderers.
+type renderBlock struct {
+	Heading  string // set when the speaker changes from the previous block
+	Kind     string // message kind (see KindOf)
+	Text     string
+	ToolName string
+	ToolID   string
+	IsError  bool
+	Usage    string // formatted usage annotation, if any
+}
+
+// formatUsage renders token figures as a single line.
+func formatUsage(u Usage) string {
+	return fmt.Sprintf("%d input · %d output · %d cache write · %d cache read tokens",
+		u.InputTokens, u.OutputTokens, u.CacheCreationInputTokens, u.CacheReadInputTokens)
+}
+
+// prettyJSON indents raw JSON, falling back to the raw text if it is invalid.
+func prettyJSON(raw json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, raw, "", "  "); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
+
+// renderBlocks converts a session into render blocks.
+func renderBlocks(s Session, cfg *renderConfig) []renderBlock {
+	redact := cfg.redact
+	if redact == nil {
+		redact = func(s string) string { return s }
+	}
+
+	names := make(map[string]string) // tool call ID → tool name
+	for _, tc := range MessagesOf[ToolCallMessage](s) {
+		names[tc.ID] = tc.Name
+	}
+
+	var blocks []renderBlock
+	speaker := ""
+	for i, msg := range s.Messages {
+		b := renderBlock{Kind: msg.messageKind()}
+		who := ""
+		switch m := msg.(type) {
+		case SystemMessage:
+			who, b.Text = "System", m.Content
+		case UserMessage:
+			who, b.Text = "User", m.Content
+		case AssistantMessage:
+			who, b.Text = "Assistant", m.Content
+		case ThinkingMessage:
+			who, b.Text = "Assistant", m.Content
+		case ToolCallMessage:
+			who, b.Text = "Assistant", prettyJSON(m.Input)
+			b.ToolName, b.ToolID = m.Name, m.ID
+		case ToolResultMessage:
+			who, b.Text = "Tool", m.Output
+			b.ToolName, b.ToolID = names[m.ID], m.ID
+			b.IsError = IsErrorResult(m)
+		case OpaqueMessage:
+			who, b.Text = "Unknown", prettyJSON(m.Raw)
+		}
+		b.Text = redact(b.Text)
+		if who != speaker {
+			b.Heading, speaker = who, who
+		}
+		if u, ok := cfg.usageAt[i]; ok {
+			b.Usage = formatUsage(u)
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks
+}
+
+// renderPlan returns the redacted plan stored in the session, if any.
+func renderPlan(s Session, cfg *renderConfig) *Plan {
+	p, ok, err := PlanFromSession(s)
+	if !ok || err != nil {
+		return nil
+	}
+	if cfg.redact != nil {
+		p.Goal = cfg.redact(p.Goal)
+		for i := range p.Steps {
+			p.Steps[i].Description = cfg.redact(p.Steps[i].Description)
+			p.Steps[i].Result = cfg.redact(p.Steps[i].Result)
+		}
+	}
+	return &p
+}
+
+// renderNotebook returns the redacted notebook stored in the session, if
+// it has any content.
+func renderNotebook(s Session, cfg *renderConfig) *Notebook {
+	n, err := NotebookFromSession(s)
+	if err != nil || len(n.Todos) == 0 && len(n.Notes) == 0 {
+		return nil
+	}
+	if cfg.redact != nil {
+		for i := range n.Todos {
+			n.Todos[i].Text = cfg.redact(n.Todos[i].Text)
+		}
+		for k, v := range n.Notes {
+			n.Notes[k] = cfg.redact(v)
+		}
+	}
+	return &n
+}
+
+func newRenderConfig(opts []RenderOption) *renderConfig {
+	cfg := &renderConfig{title: "Transcript"}
+	for _, o := range opts {
+		o(cfg)
+	}
+	return cfg
+}
+
+// anchorID returns a fragment-safe anchor for a tool call or result.
+func anchorID(prefix, id string) string {
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	for _, r := range id {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteByte('-')
+		}
+	}
+	return sb.String()
+}
+
+// codeFence returns a backtick fence longer than any backtick run in s.
+func codeFence(s string) string {
+	longest, run := 0, 0
+	for _, r := range s {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// RenderMarkdown renders the session as a Markdown transcript.  Thinking is
+// placed in collapsible <details> blocks, tool inputs are pretty-printed,
+// and each tool result links back to the call it answers.  A plan stored by
+// PlanAndExecute and a notebook stored by WithNotebook are shown before the
+// messages.
+func RenderMarkdown(s Session, opts ...RenderOption) string {
+	cfg := newRenderConfig(opts)
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "# %s\n\n", cfg.title)
+	if cfg.totalUsage != nil {
+		fmt.Fprintf(&sb, "_Total usage: %s_\n\n", formatUsage(*cfg.totalUsage))
+	}
+	if p := renderPlan(s, cfg); p != nil {
+		fmt.Fprintf(&sb, "## Plan\n\n%s\n", p.Markdown())
+	}
+	if n := renderNotebook(s, cfg); n != nil {
+		fmt.Fprintf(&sb, "## Notebook\n\n%s\n", strings.TrimSuffix(n.Markdown(), "\n"))
+	}
+
+	for _, b := range renderBlocks(s, cfg) {
+		if b.Heading != "" {
+			fmt.Fprintf(&sb, "## %s\n\n", b.Heading)
+		}
+		switch b.Kind {
+		case KindThinking:
+			fmt.Fprintf(&sb, "<details>\n<summary>Thinking</summary>\n\n%s\n\n</details>\n\n", b.Text)
+		case KindToolCall:
+			fence := codeFence(b.Text)
+			fmt.Fprintf(&sb, "<a id=\"%s\"></a>\n**Tool call** `%s` (%s) → [result](#%s)\n\n%sjson\n%s\n%s\n\n",
+				anchorID("call-", b.ToolID), b.ToolName, b.ToolID, anchorID("result-", b.ToolID), fence, b.Text, fence)
+		case KindToolResult:
+			label := "Tool result"
+			if b.IsError {
+				label = "Tool error"
+			}
+			fence := codeFence(b.Text)
+			fmt.Fprintf(&sb, "<a id=\"%s\"></a>\n**%s** for [`%s` (%s)](#%s)\n\n%s\n%s\n%s\n\n",
+				anchorID("result-", b.ToolID), label, b.ToolName, b.ToolID, anchorID("call-", b.ToolID), fence, b.Text, fence)
+		case KindOpaque:
+			fence := codeFence(b.Text)
+			fmt.Fprintf(&sb, "%sjson\n%s\n%s\n\n", fence, b.Text, fence)
+		default:
+			fmt.Fprintf(&sb, "%s\n\n", b.Text)
+		}
+		if b.Usage != "" {
+			fmt.Fprintf(&sb, "> _Usage: %s_\n\n", b.Usage)
+		}
+	}
+	return sb.String()
+}
+
+var transcriptTemplate = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"anchor": anchorID,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 50rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.5; color: #222; }
+h2 { font-size: 1rem; text-transform: uppercase; letter-spacing: .05em; color: #666; border-top: 1px solid #ddd; padding-top: 1rem; }
+.msg { white-space: pre-wrap; margin: .5rem 0; }
+pre { background: #f6f8fa; padding: .75rem; overflow-x: auto; border-radius: 4px; }
+details { color: #555; margin: .5rem 0; }
+details pre { background: #fafafa; }
+.tool { border-left: 3px solid #4a7; padding-left: .75rem; margin: .75rem 0; }
+.tool.error { border-color: #c44; }
+.usage { font-size: .8rem; color: #888; }
+.step.done { color: #4a7; }
+.step.failed { color: #c44; }
+.todo.done { text-decoration: line-through; color: #888; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{with .Total}}<p class="usage">Total usage: {{.}}</p>
+{{end}}{{with .Plan}}<h2>Plan</h2>
+<p><strong>Goal:</strong> {{.Goal}}</p>
+<ol>{{range .Steps}}<li class="step {{.Status}}">{{.Description}}{{with .Result}}<pre>{{.}}</pre>{{end}}</li>{{end}}</ol>
+{{end}}{{with .Notebook}}<h2>Notebook</h2>
+{{with .Todos}}<ul>{{range .}}<li class="todo {{.Status}}">{{.Text}}</li>{{end}}</ul>
+{{end}}{{range $k, $v := .Notes}}<p><strong>{{$k}}</strong></p><pre>{{$v}}</pre>
+{{end}}{{end}}{{range .Blocks}}{{with .Heading}}<h2>{{.}}</h2>
+{{end}}{{if eq .Kind "thinking"}}<details><summary>Thinking</summary><pre>{{.Text}}</pre></details>
+{{else if eq .Kind "tool_call"}}<div class="tool" id="{{anchor "call-" .ToolID}}"><strong>Tool call</strong> <code>{{.ToolName}}</code> ({{.ToolID}}) → <a href="#{{anchor "result-" .ToolID}}">result</a><pre>{{.Text}}</pre></div>
+{{else if eq .Kind "tool_result"}}<div class="tool{{if .IsError}} error{{end}}" id="{{anchor "result-" .ToolID}}"><strong>{{if .IsError}}Tool error{{else}}Tool result{{end}}</strong> for <a href="#{{anchor "call-" .ToolID}}"><code>{{.ToolName}}</code> ({{.ToolID}})</a><pre>{{.Text}}</pre></div>
+{{else if eq .Kind "opaque"}}<pre>{{.Text}}</pre>
+{{else}}<div class="msg">{{.Text}}</div>
+{{end}}{{with .Usage}}<p class="usage">Usage: {{.}}</p>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// RenderHTML renders the session as a self-contained HTML page with inline
+// styles and no external resources.  Content is HTML-escaped.
+func RenderHTML(s Session, opts ...RenderOption) (string, error) {
+	cfg := newRenderConfig(opts)
+	data := struct {
+		Title    string
+		Total    string
+		Plan     *Plan
+		Notebook *Notebook
+		Blocks   []renderBlock
+	}{Title: cfg.title, Plan: renderPlan(s, cfg), Notebook: renderNotebook(s, cfg), Blocks: renderBlocks(s, cfg)}
+	if cfg.totalUsage != nil {
+		data.Total = formatUsage(*cfg.totalUsage)
+	}
+
+	var buf bytes.Buffer
+	if err := transcriptTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
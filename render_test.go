@@ -0,0 +1,77 @@
+package agentloop
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	md := RenderMarkdown(querySession(),
+		WithTitle("Calc run"),
+		WithTotalUsage(Usage{InputTokens: 120, OutputTokens: 30}),
+		WithMessageUsage(map[int]Usage{8: {InputTokens: 60, OutputTokens: 10}}),
+	)
+	t.Log(md)
+
+	for _, want := range []string{
+		"# Calc run\n",
+		"_Total usage: 120 input · 30 output · 0 cache write · 0 cache read tokens_",
+		"## System\n\nYou are a calculator.",
+		"<details>\n<summary>Thinking</summary>\n\nI should add.\n\n</details>",
+		"<a id=\"call-c1\"></a>\n**Tool call** `add` (c1) → [result](#result-c1)",
+		"```json\n{\n  \"a\": 1,\n  \"b\": 2\n}\n```",
+		"## Tool\n\n<a id=\"result-c1\"></a>\n**Tool result** for [`add` (c1)](#call-c1)",
+		"**Tool error** for [`lookup` (c2)](#call-c2)",
+		"> _Usage: 60 input · 10 output",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown missing %q", want)
+		}
+	}
+
+	// Consecutive assistant-side messages share one heading.
+	if n := strings.Count(md, "## Assistant"); n != 2 {
+		t.Errorf("got %d assistant headings, want 2", n)
+	}
+}
+
+func TestRenderMarkdownFence(t *testing.T) {
+	s := InitSession("sys", "u")
+	s.Add(ToolResultMessage{ID: "c1", Output: "has ``` inside"})
+	md := RenderMarkdown(s)
+	if !strings.Contains(md, "````\nhas ``` inside\n````") {
+		t.Errorf("fence not lengthened:\n%s", md)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	s := querySession()
+	s.Add(UserMessage{"<script>alert('x')</script> my key is sk-secret"})
+
+	page, err := RenderHTML(s, WithRedactor(func(text string) string {
+		return strings.ReplaceAll(text, "sk-secret", "[REDACTED]")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"<!DOCTYPE html>",
+		"<style>",
+		"<details><summary>Thinking</summary><pre>I should add.</pre></details>",
+		`<div class="tool" id="call-c1">`,
+		`<a href="#call-c1"><code>add</code> (c1)</a>`,
+		`<div class="tool error" id="result-c2">`,
+		"&lt;script&gt;",
+		"[REDACTED]",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("html missing %q", want)
+		}
+	}
+	for _, bad := range []string{"<script>", "sk-secret", "http://", "https://"} {
+		if strings.Contains(page, bad) {
+			t.Errorf("html unexpectedly contains %q", bad)
+		}
+	}
+}
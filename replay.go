@@ -0,0 +1,244 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrReplayExhausted is returned by a replayed model when the live run asks
+// for more responses than the recording contains.
+var ErrReplayExhausted = errors.New("replay: recording has no more model responses")
+
+// Divergence records a point where a live run differs from its recording.
+type Divergence struct {
+	Index    int     // position in the recorded session; -1 if not tied to one
+	Recorded Message // nil if the recording has no message here
+	Live     Message // nil if the live run has no message here
+}
+
+func (d Divergence) String() string {
+	describe := func(m Message) string {
+		if m == nil {
+			return "(none)"
+		}
+		return summarizeMessage(m)
+	}
+	where := "tool call"
+	if d.Index >= 0 {
+		where = fmt.Sprintf("message %d", d.Index)
+	}
+	return fmt.Sprintf("%s: recorded %s; live %s", where, describe(d.Recorded), describe(d.Live))
+}
+
+// Replay drives an agent from a recorded session.  Model responses, tool
+// results, or both can be served from the recording while the rest runs
+// live; every difference between the live run and the recording is
+// collected as a Divergence.
+//
+// A Replay is safe for concurrent use, but is intended for a single run.
+type Replay struct {
+	recorded  Session
+	prompt    int   // number of messages before the first model response
+	responses []int // start index of each model response in recorded
+
+	mu          sync.Mutex
+	next        int                            // next response to serve
+	checked     int                            // recorded positions already compared
+	toolOutputs map[string][]ToolResultMessage // call key → recorded results
+	divergences []Divergence
+}
+
+// NewReplay prepares a replay of recorded.  A model response is a maximal
+// run of AssistantMessage, ThinkingMessage and ToolCallMessage values.
+func NewReplay(recorded Session) *Replay {
+	r := &Replay{recorded: recorded, prompt: len(recorded.Messages), toolOutputs: make(map[string][]ToolResultMessage)}
+	inResponse := false
+	for i, msg := range recorded.Messages {
+		switch msg.(type) {
+		case AssistantMessage, ThinkingMessage, ToolCallMessage:
+			if !inResponse {
+				r.responses = append(r.responses, i)
+				inResponse = true
+			}
+		default:
+			inResponse = false
+		}
+	}
+	if len(r.responses) > 0 {
+		r.prompt = r.responses[0]
+	}
+	for _, ex := range recorded.ToolExchanges() {
+		if ex.Result != nil {
+			key := toolCallKey(ex.Call.Name, ex.Call.Input)
+			r.toolOutputs[key] = append(r.toolOutputs[key], *ex.Result)
+		}
+	}
+	return r
+}
+
+// toolCallKey identifies a call by tool name and canonical input (sorted
+// keys, no whitespace), since call IDs differ between runs.
+func toolCallKey(name string, input json.RawMessage) string {
+	var v any
+	if err := json.Unmarshal(input, &v); err != nil {
+		return name + "\x00" + string(input)
+	}
+	return name + "\x00" + canonicalJSON(v)
+}
+
+// Prompt returns the recorded messages that preceded the first model
+// response, suitable as the starting session of a live run.
+func (r *Replay) Prompt() Session {
+	var s Session
+	for i, m := range r.recorded.Messages[:r.prompt] {
+		s.addAt(r.recorded.Time(i), m)
+	}
+	return s
+}
+
+// Model returns an InvokeModelFunc that serves the recorded model responses
+// in order.  Before each response it compares the live session with the
+// recording up to that point and records any divergence, so a changed tool
+// result is reported at the position where it first appeared.
+func (r *Replay) Model() InvokeModelFunc {
+	return func(_ context.Context, _ []ToolDefinition, live Session) ([]Message, Usage, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.next >= len(r.responses) {
+			return nil, Usage{}, ErrReplayExhausted
+		}
+		start := r.responses[r.next]
+		r.next++
+
+		r.compare(live, start)
+
+		end := start
+		for end < len(r.recorded.Messages) && isModelOutput(r.recorded.Messages[end]) {
+			end++
+		}
+		return append([]Message(nil), r.recorded.Messages[start:end]...), Usage{}, nil
+	}
+}
+
+// isModelOutput reports whether msg is part of a model response.
+func isModelOutput(msg Message) bool {
+	switch msg.(type) {
+	case AssistantMessage, ThinkingMessage, ToolCallMessage:
+		return true
+	default:
+		return false
+	}
+}
+
+// replayEqual reports whether a live message matches its recording.  A
+// thinking block or tool output that one side has compacted (see
+// defaultCompactor) matches the full text on the other side, since the
+// recording was captured after compaction.
+func replayEqual(rec, live Message) bool {
+	if messagesEqual(rec, live) {
+		return true
+	}
+	truncationOf := func(a, b string) bool {
+		p, ok := strings.CutSuffix(a, "…")
+		return ok && strings.HasPrefix(b, p)
+	}
+	switch r := rec.(type) {
+	case ToolResultMessage:
+		l, ok := live.(ToolResultMessage)
+		return ok && r.ID == l.ID && (truncationOf(r.Output, l.Output) || truncationOf(l.Output, r.Output))
+	case ThinkingMessage:
+		l, ok := live.(ThinkingMessage)
+		return ok && (truncationOf(r.Content, l.Content) || truncationOf(l.Content, r.Content))
+	default:
+		return false
+	}
+}
+
+// compare records divergences between live and the recording over the
+// positions not yet checked, up to (but excluding) upto.
+func (r *Replay) compare(live Session, upto int) {
+	at := func(msgs []Message, i int) Message {
+		if i < len(msgs) {
+			return msgs[i]
+		}
+		return nil
+	}
+	for i := r.checked; i < upto; i++ {
+		rec, got := at(r.recorded.Messages, i), at(live.Messages, i)
+		if !replayEqual(rec, got) {
+			r.divergences = append(r.divergences, Divergence{Index: i, Recorded: rec, Live: got})
+		}
+	}
+	r.checked = max(r.checked, upto)
+}
+
+// Tools wraps tools so that calls matching a recorded call (same tool name
+// and input) return the recorded output instead of running the handler.
+// Calls the recording does not contain are reported as divergences and
+// passed to the live handler.  Recorded outputs beginning with "Error: " are
+// returned as errors so ExecuteToolCalls reproduces them exactly.
+func (r *Replay) Tools(tools []Tool) []Tool {
+	out := make([]Tool, len(tools))
+	for i, t := range tools {
+		name, live := t.Definition.Name, t.Handler
+		out[i] = Tool{
+			Definition: t.Definition,
+			Handler: func(ctx context.Context, input json.RawMessage) (string, error) {
+				key := toolCallKey(name, input)
+				r.mu.Lock()
+				queue := r.toolOutputs[key]
+				if len(queue) == 0 {
+					r.divergences = append(r.divergences, Divergence{
+						Index: -1,
+						Live:  ToolCallMessage{Name: name, Input: input},
+					})
+					r.mu.Unlock()
+					if live == nil {
+						return "", fmt.Errorf("no recorded result for %s call", name)
+					}
+					return live(ctx, input)
+				}
+				res := queue[0]
+				r.toolOutputs[key] = queue[1:]
+				r.mu.Unlock()
+
+				if msg, ok := strings.CutPrefix(res.Output, "Error: "); ok {
+					return "", errors.New(msg)
+				}
+				return res.Output, nil
+			},
+		}
+	}
+	return out
+}
+
+// Finish compares the final live session with the whole recording and
+// records any remaining divergences, including messages only one side has.
+func (r *Replay) Finish(live Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.compare(live, max(len(r.recorded.Messages), len(live.Messages)))
+}
+
+// Divergences returns the divergences found so far, in discovery order.
+func (r *Replay) Divergences() []Divergence {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Divergence(nil), r.divergences...)
+}
+
+// ReplaySession re-runs a recorded session with the model's responses served
+// from the recording and tools run live, returning the live session and
+// every point where it diverged from the recording.  opts are passed to
+// AgentLoop.
+func ReplaySession(ctx context.Context, recorded Session, tools []Tool, opts ...AgentLoopOption) (Session, []Divergence, error) {
+	r := NewReplay(recorded)
+	live, err := AgentLoop(ctx, r.Model(), tools, r.Prompt(), opts...)
+	r.Finish(live)
+	return live, r.Divergences(), err
+}
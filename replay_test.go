@@ -0,0 +1,138 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// recordedRun is a two-iteration transcript of the add tool.
+func recordedRun() Session {
+	s := InitSession("sys", "What is 2 + 2, then 3 + 3?")
+	s.Add(
+		ThinkingMessage{"Add twice."},
+		ToolCallMessage{ID: "c1", Name: "add", Input: json.RawMessage(`{"a":2,"b":2}`)},
+		ToolResultMessage{ID: "c1", Output: "4"},
+		ToolCallMessage{ID: "c2", Name: "add", Input: json.RawMessage(`{"a":3,"b":3}`)},
+		ToolResultMessage{ID: "c2", Output: "6"},
+		AssistantMessage{"4 and 6."},
+	)
+	return s
+}
+
+// addTool returns an add tool whose handler applies op to the operands.
+func addTool(op func(a, b int) int) Tool {
+	return Tool{
+		Definition: ToolDefinition{Name: "add", InputSchema: ToolInputSchema{Type: "object"}},
+		Handler: func(_ context.Context, input json.RawMessage) (string, error) {
+			var args struct{ A, B int }
+			if err := json.Unmarshal(input, &args); err != nil {
+				return "", err
+			}
+			return strconv.Itoa(op(args.A, args.B)), nil
+		},
+	}
+}
+
+func TestReplaySessionNoDivergence(t *testing.T) {
+	live, divs, err := ReplaySession(context.Background(), recordedRun(),
+		[]Tool{addTool(func(a, b int) int { return a + b })}, WithCompactor(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(divs) != 0 {
+		t.Errorf("unexpected divergences: %v", divs)
+	}
+	if !DiffSessions(recordedRun(), live).Identical() {
+		t.Errorf("live run differs:\n%s", DiffSessions(recordedRun(), live))
+	}
+}
+
+// TestReplaySessionToolChanged breaks the tool for one input and checks the
+// divergence is reported at the recorded position of the changed result.
+func TestReplaySessionToolChanged(t *testing.T) {
+	buggy := addTool(func(a, b int) int {
+		if a == 3 {
+			return a * b
+		}
+		return a + b
+	})
+	_, divs, err := ReplaySession(context.Background(), recordedRun(), []Tool{buggy}, WithCompactor(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(divs) != 1 {
+		t.Fatalf("got %d divergences, want 1: %v", len(divs), divs)
+	}
+	d := divs[0]
+	if d.Index != 6 || d.Recorded.(ToolResultMessage).Output != "6" || d.Live.(ToolResultMessage).Output != "9" {
+		t.Errorf("divergence: %v", d)
+	}
+	if !strings.Contains(d.String(), "message 6: recorded tool_result: 6; live tool_result: 9") {
+		t.Errorf("String() = %q", d.String())
+	}
+}
+
+// TestReplayToleratesCompaction confirms that a recording captured after
+// compaction matches a live run whose outputs have not been compacted.
+func TestReplayToleratesCompaction(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	rec := InitSession("sys", "u")
+	rec.Add(
+		ToolCallMessage{ID: "c1", Name: "echo", Input: json.RawMessage(`{}`)},
+		ToolResultMessage{ID: "c1", Output: long[:200] + "…"},
+		AssistantMessage{"done"},
+	)
+	echo := Tool{
+		Definition: ToolDefinition{Name: "echo", InputSchema: ToolInputSchema{Type: "object"}},
+		Handler:    func(context.Context, json.RawMessage) (string, error) { return long, nil },
+	}
+	_, divs, err := ReplaySession(context.Background(), rec, []Tool{echo}, WithCompactor(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(divs) != 0 {
+		t.Errorf("unexpected divergences: %v", divs)
+	}
+}
+
+// TestReplayTools runs a scripted "live" model against tools served from the
+// recording and checks that an unrecorded call falls through to the handler
+// and is reported.
+func TestReplayTools(t *testing.T) {
+	r := NewReplay(recordedRun())
+	handlerCalls := 0
+	tools := r.Tools([]Tool{addTool(func(a, b int) int { handlerCalls++; return a + b })})
+
+	results := ExecuteToolCalls(context.Background(), []ToolCallMessage{
+		{ID: "x1", Name: "add", Input: json.RawMessage(`{ "b": 2, "a": 2 }`)},
+		{ID: "x2", Name: "add", Input: json.RawMessage(`{"a":5,"b":5}`)},
+	}, map[string]ToolHandler{"add": tools[0].Handler})
+
+	if got := results[0].(ToolResultMessage).Output; got != "4" {
+		t.Errorf("recorded result: got %q", got)
+	}
+	if got := results[1].(ToolResultMessage).Output; got != "10" {
+		t.Errorf("live fallback: got %q", got)
+	}
+	if handlerCalls != 1 {
+		t.Errorf("handler called %d times, want 1", handlerCalls)
+	}
+	divs := r.Divergences()
+	if len(divs) != 1 || divs[0].Index != -1 || divs[0].Live.(ToolCallMessage).Name != "add" {
+		t.Errorf("divergences: %v", divs)
+	}
+}
+
+func TestReplayExhausted(t *testing.T) {
+	rec := InitSession("sys", "u")
+	rec.Add(ToolCallMessage{ID: "c1", Name: "noop", Input: json.RawMessage(`{}`)})
+
+	_, _, err := ReplaySession(context.Background(), rec, []Tool{noopTool})
+	if !errors.Is(err, ErrReplayExhausted) {
+		t.Errorf("got %v, want ErrReplayExhausted", err)
+	}
+}
@@ -0,0 +1,164 @@
+package agentloop
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Run records one AgentLoop call and where it sits in a tree of nested
+// loops.  A loop started from a tool handler finds its parent through the
+// handler's context, so subagents are linked without any wiring by the
+// caller.
+type Run struct {
+	ID         string
+	ParentID   string // empty for a top-level run
+	ToolCallID string // the parent's tool call whose handler started the run
+
+	Started time.Time
+	Ended   time.Time // zero while the run is in progress
+	Session Session   // the final session; empty while the run is in progress
+	Usage   Usage
+	Err     error
+}
+
+// RunRegistry collects the runs started under a context (see
+// ContextWithRunRegistry), so child sessions remain available after the
+// tool handlers that ran them have returned.  A child's session is found
+// from the parent's ToolResultMessage with Children(parentID, result.ID).
+// A RunRegistry is safe for concurrent use.
+type RunRegistry struct {
+	mu   sync.Mutex
+	runs []*Run
+	byID map[string]*Run
+}
+
+// NewRunRegistry returns an empty registry.
+func NewRunRegistry() *RunRegistry {
+	return &RunRegistry{byID: map[string]*Run{}}
+}
+
+func (r *RunRegistry) start(run *Run) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs = append(r.runs, run)
+	r.byID[run.ID] = run
+}
+
+func (r *RunRegistry) finish(id string, session Session, usage Usage, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if run, ok := r.byID[id]; ok {
+		run.Ended, run.Session, run.Usage, run.Err = time.Now(), session, usage, err
+	}
+}
+
+// Get returns a copy of the run with the given ID.
+func (r *RunRegistry) Get(id string) (Run, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run, ok := r.byID[id]
+	if !ok {
+		return Run{}, false
+	}
+	return *run, true
+}
+
+// Runs returns copies of all runs in the order they started.
+func (r *RunRegistry) Runs() []Run {
+	return r.filter(func(*Run) bool { return true })
+}
+
+// Children returns the runs started by tool calls of the run parentID, in
+// the order they started.  A non-empty toolCallID limits them to the runs
+// started by that call.
+func (r *RunRegistry) Children(parentID, toolCallID string) []Run {
+	return r.filter(func(run *Run) bool {
+		return run.ParentID == parentID && (toolCallID == "" || run.ToolCallID == toolCallID)
+	})
+}
+
+func (r *RunRegistry) filter(keep func(*Run) bool) []Run {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []Run
+	for _, run := range r.runs {
+		if keep(run) {
+			out = append(out, *run)
+		}
+	}
+	return out
+}
+
+type (
+	runRegistryKey struct{}
+	runIDKey       struct{}
+	toolCallIDKey  struct{}
+)
+
+// ContextWithRunRegistry returns a context carrying r.  Every AgentLoop run
+// with the context, including loops nested in its tool handlers, is
+// recorded in r.
+func ContextWithRunRegistry(ctx context.Context, r *RunRegistry) context.Context {
+	return context.WithValue(ctx, runRegistryKey{}, r)
+}
+
+// RunRegistryFromContext returns the registry stored by
+// ContextWithRunRegistry.
+func RunRegistryFromContext(ctx context.Context) (*RunRegistry, bool) {
+	r, ok := ctx.Value(runRegistryKey{}).(*RunRegistry)
+	return r, ok
+}
+
+// RunIDFromContext returns the ID of the AgentLoop run whose model call or
+// tool handler ctx belongs to.
+func RunIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(runIDKey{}).(string)
+	return id, ok
+}
+
+// ToolCallIDFromContext returns the ID of the tool call being handled, in
+// the context ExecuteToolCalls passes to a handler.
+func ToolCallIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(toolCallIDKey{}).(string)
+	return id, ok
+}
+
+// WithRunListener sets a function called with the run's ID as the loop
+// starts, so the caller can look the run up in a RunRegistry afterwards
+// and follow it to its children.
+func WithRunListener(fn func(id string)) AgentLoopOption {
+	return func(c *agentLoopConfig) { c.onRun = fn }
+}
+
+func newRunID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return "run_" + hex.EncodeToString(b[:])
+}
+
+// startRun assigns a new run its ID, links it to the run and tool call
+// in ctx and records it in the context's registry, if any.  It returns the
+// context for the run's model calls and tool handlers, and a function to
+// record how the run ended.  The tool call ID is cleared from the returned
+// context once recorded: it belongs to the parent's handler, and loops the
+// run starts outside its own tool handlers, such as a critic, must not be
+// linked to it.
+func startRun(ctx context.Context) (context.Context, func(Session, Usage, error)) {
+	run := &Run{ID: newRunID(), Started: time.Now()}
+	run.ParentID, _ = RunIDFromContext(ctx)
+	run.ToolCallID, _ = ToolCallIDFromContext(ctx)
+	ctx = context.WithValue(ctx, runIDKey{}, run.ID)
+	if run.ToolCallID != "" {
+		ctx = context.WithValue(ctx, toolCallIDKey{}, nil)
+	}
+
+	reg, ok := RunRegistryFromContext(ctx)
+	if !ok {
+		return ctx, func(Session, Usage, error) {}
+	}
+	reg.start(run)
+	return ctx, func(s Session, u Usage, err error) { reg.finish(run.ID, s, u, err) }
+}
@@ -0,0 +1,100 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// TestRunRegistry runs a parent whose single turn delegates two tasks to an
+// AgentTool and checks that each child session can be found from the
+// parent's tool results.
+func TestRunRegistry(t *testing.T) {
+	child := func(_ context.Context, _ []ToolDefinition, s Session) ([]Message, Usage, error) {
+		return []Message{AssistantMessage{"re: " + s.Messages[1].(UserMessage).Content}}, Usage{OutputTokens: 1}, nil
+	}
+	research := AgentTool("research", "", "sys", child, nil)
+
+	parent, _ := scriptedModel([]Message{
+		ToolCallMessage{ID: "c1", Name: "research", Input: json.RawMessage(`{"task":"one"}`)},
+		ToolCallMessage{ID: "c2", Name: "research", Input: json.RawMessage(`{"task":"two"}`)},
+	})
+	reg := NewRunRegistry()
+	ctx := ContextWithRunRegistry(context.Background(), reg)
+	var rootID string
+	session, err := AgentLoop(ctx, parent, []Tool{research}, InitSession("sys", "go"),
+		WithRunListener(func(id string) { rootID = id }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs := reg.Runs()
+	if len(runs) != 3 {
+		t.Fatalf("got %d runs, want 3", len(runs))
+	}
+	root, ok := reg.Get(rootID)
+	if !ok || root.ID != runs[0].ID {
+		t.Fatalf("listener reported %q, want the first run %q", rootID, runs[0].ID)
+	}
+	if root.ParentID != "" || root.Ended.IsZero() || len(root.Session.Messages) != len(session.Messages) ||
+		root.Usage.InputTokens != 2 {
+		t.Errorf("root run: %+v", root)
+	}
+
+	for _, m := range session.Messages {
+		result, ok := m.(ToolResultMessage)
+		if !ok {
+			continue
+		}
+		children := reg.Children(root.ID, result.ID)
+		if len(children) != 1 {
+			t.Fatalf("%s: got %d children, want 1", result.ID, len(children))
+		}
+		c := children[0]
+		answer, _ := c.Session.FinalAnswer()
+		if c.ParentID != root.ID || c.ToolCallID != result.ID || answer != result.Output || c.Err != nil {
+			t.Errorf("%s: child %+v does not match result %q", result.ID, c, result.Output)
+		}
+		if got, ok := reg.Get(c.ID); !ok || got.ToolCallID != result.ID {
+			t.Errorf("Get(%s) = %+v, %v", c.ID, got, ok)
+		}
+	}
+	if len(reg.Children(root.ID, "")) != 2 {
+		t.Errorf("got %d children of the root, want 2", len(reg.Children(root.ID, "")))
+	}
+}
+
+// TestRunToolCallIDNotInherited checks that a loop a subagent starts outside
+// its tool handlers, here its critic, is linked to the subagent but not to
+// the tool call that started the subagent.
+func TestRunToolCallIDNotInherited(t *testing.T) {
+	criticModel, _ := scriptedModel([]Message{submit("k1", `{"approved":true}`)})
+	child := func(context.Context, []ToolDefinition, Session) ([]Message, Usage, error) {
+		return []Message{AssistantMessage{"found it"}}, Usage{}, nil
+	}
+	research := AgentTool("research", "", "sys", child, nil,
+		WithLoopOptions(WithReflection(Critic(criticModel, ""), 1)))
+	parent, _ := scriptedModel([]Message{
+		ToolCallMessage{ID: "c1", Name: "research", Input: json.RawMessage(`{"task":"one"}`)},
+	})
+
+	reg := NewRunRegistry()
+	ctx := ContextWithRunRegistry(context.Background(), reg)
+	if _, err := AgentLoop(ctx, parent, []Tool{research}, InitSession("sys", "go")); err != nil {
+		t.Fatal(err)
+	}
+	runs := reg.Runs()
+	if len(runs) != 3 {
+		t.Fatalf("got %d runs, want parent, subagent and critic", len(runs))
+	}
+	sub, critic := runs[1], runs[2]
+	if sub.ParentID != runs[0].ID || sub.ToolCallID != "c1" {
+		t.Errorf("subagent: %+v", sub)
+	}
+	if critic.ParentID != sub.ID || critic.ToolCallID != "" {
+		t.Errorf("critic: parent %q, tool call %q; want parent %q and no tool call", critic.ParentID, critic.ToolCallID, sub.ID)
+	}
+	if len(reg.Children(runs[0].ID, "c1")) != 1 {
+		t.Errorf("c1 has %d children, want 1", len(reg.Children(runs[0].ID, "c1")))
+	}
+}
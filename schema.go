@@ -0,0 +1,189 @@
+package agentloop
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CurrentSessionVersion is the schema version written by Session.MarshalJSON.
+//
+// Version history:
+//
+//	1: bare JSON array of messages (no envelope)
+//	2: {"version": 2, "messages": [...]}
+//	3: {"version": 3, "messages": [...], "state": {...}} (state optional)
+//	4: {"version": 4, "messages": [...], "times": [...], "state": {...}}
+//	   (times optional, one RFC 3339 time or null per message)
+const CurrentSessionVersion = 4
+
+// sessionEnvelope is the on-disk form of a Session from version 2 onwards.
+type sessionEnvelope struct {
+	Version  int                        `json:"version"`
+	Messages []Message                  `json:"messages"`
+	Times    []*time.Time               `json:"times,omitempty"`
+	State    map[string]json.RawMessage `json:"state,omitempty"`
+}
+
+// MigrationFunc upgrades a session document by exactly one version: it
+// receives a document at version N and returns the equivalent document at
+// version N+1.
+type MigrationFunc func(doc json.RawMessage) (json.RawMessage, error)
+
+var (
+	migrationsMu sync.RWMutex
+	migrations   = map[int]MigrationFunc{
+		1: migrateV1ToV2,
+		2: migrateV2ToV3,
+		3: migrateV3ToV4,
+	}
+)
+
+// RegisterMigration installs fn as the migration from version `from` to
+// from+1, replacing any existing migration for that version.  It is safe to
+// call concurrently with decoding.
+func RegisterMigration(from int, fn MigrationFunc) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	migrations[from] = fn
+}
+
+// migrateV1ToV2 wraps a bare message array in a version 2 envelope.
+func migrateV1ToV2(doc json.RawMessage) (json.RawMessage, error) {
+	return json.Marshal(struct {
+		Version  int             `json:"version"`
+		Messages json.RawMessage `json:"messages"`
+	}{2, doc})
+}
+
+// migrateV2ToV3 only bumps the version: version 3 adds the optional state
+// object, which version 2 readers would silently drop.
+func migrateV2ToV3(doc json.RawMessage) (json.RawMessage, error) {
+	var env map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &env); err != nil {
+		return nil, err
+	}
+	env["version"] = json.RawMessage("3")
+	return json.Marshal(env)
+}
+
+// migrateV3ToV4 only bumps the version: version 4 adds the optional times
+// array, which version 3 readers would silently drop.
+func migrateV3ToV4(doc json.RawMessage) (json.RawMessage, error) {
+	var env map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &env); err != nil {
+		return nil, err
+	}
+	env["version"] = json.RawMessage("4")
+	return json.Marshal(env)
+}
+
+// documentVersion reports the schema version of a raw session document.
+// Bare arrays are version 1; objects carry an explicit "version" field.
+func documentVersion(doc json.RawMessage) (int, error) {
+	trimmed := bytes.TrimSpace(doc)
+	if len(trimmed) == 0 {
+		return 0, errors.New("empty session document")
+	}
+	switch trimmed[0] {
+	case '[':
+		return 1, nil
+	case '{':
+		var v struct {
+			Version int `json:"version"`
+		}
+		if err := json.Unmarshal(trimmed, &v); err != nil {
+			return 0, err
+		}
+		if v.Version < 1 {
+			return 0, errors.New("session document has no version")
+		}
+		return v.Version, nil
+	default:
+		return 0, errors.New("session document is neither an array nor an object")
+	}
+}
+
+// MigrateSession upgrades a raw session document of any supported version to
+// CurrentSessionVersion by applying registered migrations in sequence.
+// Documents already at the current version are returned unchanged.
+func MigrateSession(doc json.RawMessage) (json.RawMessage, error) {
+	version, err := documentVersion(doc)
+	if err != nil {
+		return nil, err
+	}
+	if version > CurrentSessionVersion {
+		return nil, fmt.Errorf("session version %d is newer than supported version %d", version, CurrentSessionVersion)
+	}
+
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+	for ; version < CurrentSessionVersion; version++ {
+		fn, ok := migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("no migration registered from session version %d", version)
+		}
+		if doc, err = fn(doc); err != nil {
+			return nil, fmt.Errorf("migrating session from version %d: %w", version, err)
+		}
+	}
+	return doc, nil
+}
+
+// DecodeOption configures DecodeSession.
+type DecodeOption func(*decodeConfig)
+
+type decodeConfig struct {
+	lenient bool
+}
+
+// WithLenientDecoding preserves messages with unknown discriminators as
+// OpaqueMessage values instead of failing.  Malformed JSON is still an error.
+func WithLenientDecoding() DecodeOption {
+	return func(c *decodeConfig) { c.lenient = true }
+}
+
+// DecodeSession decodes a session document of any supported version,
+// migrating it to CurrentSessionVersion first.
+func DecodeSession(data []byte, opts ...DecodeOption) (Session, error) {
+	cfg := &decodeConfig{}
+	for _, o := range opts {
+		o(cfg)
+	}
+
+	doc, err := MigrateSession(data)
+	if err != nil {
+		return Session{}, err
+	}
+	var env struct {
+		Messages []json.RawMessage          `json:"messages"`
+		Times    []*time.Time               `json:"times"`
+		State    map[string]json.RawMessage `json:"state"`
+	}
+	if err := json.Unmarshal(doc, &env); err != nil {
+		return Session{}, err
+	}
+
+	if len(env.Times) > len(env.Messages) {
+		return Session{}, fmt.Errorf("%d times for %d messages", len(env.Times), len(env.Messages))
+	}
+	s := Session{Messages: make([]Message, 0, len(env.Messages)), State: env.State}
+	for i, raw := range env.Messages {
+		msg, err := UnmarshalMessage(raw)
+		if errors.Is(err, ErrUnknownMessage) && cfg.lenient {
+			msg, err = OpaqueMessage{Raw: raw}, nil
+		}
+		if err != nil {
+			return Session{}, fmt.Errorf("message %d: %w", i, err)
+		}
+		var at time.Time
+		if i < len(env.Times) && env.Times[i] != nil {
+			at = *env.Times[i]
+		}
+		s.addAt(at, msg)
+	}
+	return s, nil
+}
@@ -0,0 +1,163 @@
+package agentloop
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestSessionMarshalVersioned confirms that sessions are written inside a
+// versioned envelope.
+func TestSessionMarshalVersioned(t *testing.T) {
+	data, err := json.Marshal(Session{Messages: []Message{SystemMessage{"sys"}, UserMessage{"hi"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"version":4,"messages":[{"role":"system","content":"sys"},{"role":"user","content":"hi"}]}`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+
+	empty, _ := json.Marshal(Session{})
+	if string(empty) != `{"version":4,"messages":[]}` {
+		t.Errorf("empty session: got %s", empty)
+	}
+}
+
+// TestSessionTimes checks that message times survive a round trip, with
+// null for a message appended without one.
+func TestSessionTimes(t *testing.T) {
+	s := InitSession("sys", "hi")
+	s.Messages = append(s.Messages, AssistantMessage{"untimed"})
+	s.Add(UserMessage{"again"})
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `Z",null,"`) {
+		t.Errorf("times not encoded with a null gap: %s", data)
+	}
+	var decoded Session
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for i := range s.Messages {
+		if !decoded.Time(i).Equal(s.Time(i)) {
+			t.Errorf("message %d: time %v, want %v", i, decoded.Time(i), s.Time(i))
+		}
+	}
+	if s.Time(0).IsZero() || !s.Time(2).IsZero() || s.Time(3).IsZero() || !s.Time(4).IsZero() {
+		t.Errorf("times: %v", s.Times)
+	}
+
+	if _, err := DecodeSession([]byte(`{"version":4,"messages":[],"times":[null]}`)); err == nil {
+		t.Error("expected an error for more times than messages")
+	}
+}
+
+// TestDecodeLegacyArray verifies that version 1 documents (a bare message
+// array) are migrated transparently.
+func TestDecodeLegacyArray(t *testing.T) {
+	legacy := `[{"role":"user","content":"hi"},{"type":"tool_call","id":"c1","name":"noop","input":{}}]`
+
+	var s Session
+	if err := json.Unmarshal([]byte(legacy), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(s.Messages))
+	}
+	if tc, ok := s.Messages[1].(ToolCallMessage); !ok || tc.ID != "c1" {
+		t.Errorf("message[1]: got %#v", s.Messages[1])
+	}
+
+	migrated, err := MigrateSession([]byte(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := documentVersion(migrated); v != CurrentSessionVersion {
+		t.Errorf("migrated version %d, want %d", v, CurrentSessionVersion)
+	}
+}
+
+// TestDecodeUnknownMessage checks strict and lenient handling of message
+// types this package does not know about.
+func TestDecodeUnknownMessage(t *testing.T) {
+	doc := `{"version":4,"messages":[{"role":"user","content":"hi"},{"type":"image","url":"x.png"}]}`
+
+	_, err := DecodeSession([]byte(doc))
+	if !errors.Is(err, ErrUnknownMessage) {
+		t.Fatalf("strict decode: expected ErrUnknownMessage, got %v", err)
+	}
+
+	s, err := DecodeSession([]byte(doc), WithLenientDecoding())
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, ok := s.Messages[1].(OpaqueMessage)
+	if !ok {
+		t.Fatalf("message[1]: got %T, want OpaqueMessage", s.Messages[1])
+	}
+	if string(op.Raw) != `{"type":"image","url":"x.png"}` {
+		t.Errorf("raw not preserved: %s", op.Raw)
+	}
+
+	// Opaque messages round-trip and are ignored when building API params.
+	out, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != doc {
+		t.Errorf("round trip:\n got  %s\n want %s", out, doc)
+	}
+	if _, turns := buildParams(s); len(turns) != 1 {
+		t.Errorf("expected opaque message to be skipped, got %d turns", len(turns))
+	}
+}
+
+// TestDecodeVersionErrors covers documents that cannot be migrated.
+func TestDecodeVersionErrors(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want string
+	}{
+		{`{"version":99,"messages":[]}`, "newer than supported"},
+		{`{"messages":[]}`, "no version"},
+		{`"nope"`, "neither an array nor an object"},
+	}
+	for _, tt := range tests {
+		_, err := DecodeSession([]byte(tt.doc))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want error containing %q", tt.doc, err, tt.want)
+		}
+	}
+}
+
+// TestRegisterMigration replaces the v1 migration and confirms the registry
+// entry is used during decoding.
+func TestRegisterMigration(t *testing.T) {
+	migrationsMu.RLock()
+	orig := migrations[1]
+	migrationsMu.RUnlock()
+	t.Cleanup(func() { RegisterMigration(1, orig) })
+
+	RegisterMigration(1, func(doc json.RawMessage) (json.RawMessage, error) {
+		return json.RawMessage(`{"version":2,"messages":[{"role":"user","content":"migrated"}]}`), nil
+	})
+	s, err := DecodeSession([]byte(`[]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Messages) != 1 || s.Messages[0] != (UserMessage{"migrated"}) {
+		t.Errorf("custom migration not applied: %#v", s.Messages)
+	}
+
+	RegisterMigration(1, func(json.RawMessage) (json.RawMessage, error) {
+		return nil, errors.New("boom")
+	})
+	if _, err := DecodeSession([]byte(`[]`)); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected migration error, got %v", err)
+	}
+}
@@ -2,7 +2,9 @@ package agentloop
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 )
 
 // Message is a sealed interface for all session turn types.
@@ -40,14 +42,23 @@ type ToolResultMessage struct {
 	Output string
 }
 
+// OpaqueMessage preserves a message whose discriminator this version of the
+// package does not recognise.  It is produced only by lenient decoding
+// (see WithLenientDecoding), is never sent to the model, and marshals back
+// to its original JSON unchanged.
+type OpaqueMessage struct {
+	Raw json.RawMessage
+}
+
 // -- Sealed-interface marker methods ------------------------------------
 
-func (SystemMessage) messageKind() string    { return "system" }
-func (UserMessage) messageKind() string      { return "user" }
-func (AssistantMessage) messageKind() string { return "assistant" }
-func (ThinkingMessage) messageKind() string  { return "thinking" }
-func (ToolCallMessage) messageKind() string  { return "tool_call" }
+func (SystemMessage) messageKind() string     { return "system" }
+func (UserMessage) messageKind() string       { return "user" }
+func (AssistantMessage) messageKind() string  { return "assistant" }
+func (ThinkingMessage) messageKind() string   { return "thinking" }
+func (ToolCallMessage) messageKind() string   { return "tool_call" }
 func (ToolResultMessage) messageKind() string { return "tool_result" }
+func (OpaqueMessage) messageKind() string     { return "opaque" }
 
 // -- JSON marshaling ----------------------------------------------------
 
@@ -96,8 +107,19 @@ func (m ToolResultMessage) MarshalJSON() ([]byte, error) {
 	}{"tool_result", m.ID, m.Output})
 }
 
+func (m OpaqueMessage) MarshalJSON() ([]byte, error) {
+	if len(m.Raw) == 0 {
+		return []byte("null"), nil
+	}
+	return m.Raw, nil
+}
+
 // -- JSON unmarshaling --------------------------------------------------
 
+// ErrUnknownMessage is returned (wrapped) by UnmarshalMessage when the
+// discriminator does not name a known message type.
+var ErrUnknownMessage = errors.New("unknown message discriminator")
+
 // UnmarshalMessage decodes a single Message from raw JSON by inspecting
 // the "role" or "type" discriminator field.
 func UnmarshalMessage(data []byte) (Message, error) {
@@ -162,7 +184,7 @@ func UnmarshalMessage(data []byte) (Message, error) {
 		}
 		return ToolResultMessage{v.ID, v.Output}, nil
 	default:
-		return nil, fmt.Errorf("unknown message discriminator: role=%q type=%q", disc.Role, disc.Type)
+		return nil, fmt.Errorf("%w: role=%q type=%q", ErrUnknownMessage, disc.Role, disc.Type)
 	}
 }
 
@@ -187,29 +209,117 @@ type ToolDefinition struct {
 // Session is an ordered conversation history.
 type Session struct {
 	Messages []Message
+
+	// Times records when each message was added: Times[i] belongs to
+	// Messages[i].  Add keeps it in step; messages appended to Messages
+	// directly have no time, and neither do messages from sessions saved
+	// before times were recorded.  Use Time rather than indexing it.
+	Times []time.Time
+
+	// State holds named JSON values kept with the conversation but never
+	// sent to the model, such as a plan.  Use SetState and GetState rather
+	// than writing the map directly, so copies of a session stay independent.
+	State map[string]json.RawMessage
 }
 
-// Add appends one or more messages to the session.
+// Add appends one or more messages to the session, recording the current
+// time for each.
 func (s *Session) Add(msgs ...Message) {
+	s.addAt(time.Now().UTC().Round(0), msgs...)
+}
+
+// addAt appends msgs with the given time, which may be zero.  Sessions are
+// rebuilt with it so that copied messages keep their original times.
+func (s *Session) addAt(t time.Time, msgs ...Message) {
+	if len(msgs) == 0 {
+		return
+	}
+	if t.IsZero() && len(s.Times) == 0 {
+		s.Messages = append(s.Messages, msgs...)
+		return
+	}
+	if len(s.Times) != len(s.Messages) {
+		times := make([]time.Time, len(s.Messages), len(s.Messages)+len(msgs))
+		copy(times, s.Times)
+		s.Times = times
+	}
+	for range msgs {
+		s.Times = append(s.Times, t)
+	}
 	s.Messages = append(s.Messages, msgs...)
 }
 
+// Time returns when the message at index i was added, or the zero time if
+// that is unknown.
+func (s Session) Time(i int) time.Time {
+	if i < 0 || i >= len(s.Times) {
+		return time.Time{}
+	}
+	return s.Times[i]
+}
+
+// SetState stores v, encoded as JSON, under key.  The map is copied first,
+// so sessions copied from s before the call are unaffected.
+func (s *Session) SetState(key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("state %q: %w", key, err)
+	}
+	state := make(map[string]json.RawMessage, len(s.State)+1)
+	for k, v := range s.State {
+		state[k] = v
+	}
+	state[key] = data
+	s.State = state
+	return nil
+}
+
+// GetState decodes the value stored under key into v, reporting whether
+// there was one.
+func (s Session) GetState(key string, v any) (bool, error) {
+	data, ok := s.State[key]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return true, fmt.Errorf("state %q: %w", key, err)
+	}
+	return true, nil
+}
+
+// MarshalJSON encodes the session as a versioned envelope (see
+// CurrentSessionVersion).
 func (s Session) MarshalJSON() ([]byte, error) {
-	return json.Marshal(s.Messages)
+	msgs := s.Messages
+	if msgs == nil {
+		msgs = []Message{}
+	}
+	return json.Marshal(sessionEnvelope{Version: CurrentSessionVersion, Messages: msgs, Times: s.encodeTimes(), State: s.State})
+}
+
+// encodeTimes returns one entry per message, nil where the time is
+// unknown, or nil if no message has a time.
+func (s Session) encodeTimes() []*time.Time {
+	var times []*time.Time
+	for i := range s.Messages {
+		if t := s.Time(i); !t.IsZero() {
+			if times == nil {
+				times = make([]*time.Time, len(s.Messages))
+			}
+			times[i] = &t
+		}
+	}
+	return times
 }
 
+// UnmarshalJSON decodes a session in any supported version, migrating older
+// documents first.  Unknown message types are an error; use DecodeSession
+// with WithLenientDecoding to preserve them instead.
 func (s *Session) UnmarshalJSON(data []byte) error {
-	var raws []json.RawMessage
-	if err := json.Unmarshal(data, &raws); err != nil {
+	decoded, err := DecodeSession(data)
+	if err != nil {
 		return err
 	}
-	s.Messages = make([]Message, 0, len(raws))
-	for _, raw := range raws {
-		msg, err := UnmarshalMessage(raw)
-		if err != nil {
-			return err
-		}
-		s.Messages = append(s.Messages, msg)
-	}
+	*s = decoded
 	return nil
 }
@@ -0,0 +1,93 @@
+package agentloop
+
+import (
+	"regexp"
+	"time"
+)
+
+// Iteration describes the loop iteration a StopCondition is evaluating.
+type Iteration struct {
+	Index   int       // zero-based iteration number
+	Start   int       // index in Session.Messages of the first message added by this iteration
+	Started time.Time // when the loop started
+}
+
+// New returns the messages added to s by this iteration: the model's
+// response and any tool results.
+func (it Iteration) New(s Session) []Message {
+	return s.Messages[min(it.Start, len(s.Messages)):]
+}
+
+// StopCondition reports whether the loop has reached its goal.  Conditions
+// are evaluated after each batch of tool results, with it describing the
+// iteration that produced them, so a resumed session does not stop on
+// messages from earlier runs.
+type StopCondition func(s Session, it Iteration) bool
+
+// WithStopConditions ends the loop, without error, as soon as any of conds
+// is met.  It may be given more than once.
+//
+// Conditions only cut short a loop that would otherwise continue with
+// another model call.  A response without tool calls ends the loop as
+// usual, so WithReflection still reviews it and a structured-answer loop
+// still asks for its answer.  In a structured-answer loop a met condition
+// does not end the loop without an answer: the model is instead required to
+// call submit_answer on the next iteration.
+func WithStopConditions(conds ...StopCondition) AgentLoopOption {
+	return func(c *agentLoopConfig) { c.stop = append(c.stop, conds...) }
+}
+
+// StopOnToolCall is met once the model has called the named tool and the
+// call's result has been added.
+func StopOnToolCall(name string) StopCondition {
+	return func(s Session, it Iteration) bool {
+		for _, m := range it.New(s) {
+			if tc, ok := m.(ToolCallMessage); ok && tc.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// StopOnToolResult is met by a tool result for which match returns true.
+func StopOnToolResult(match func(ToolResultMessage) bool) StopCondition {
+	return func(s Session, it Iteration) bool {
+		for _, m := range it.New(s) {
+			if tr, ok := m.(ToolResultMessage); ok && match(tr) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// StopOnText is met by an assistant text message matching re, written
+// alongside tool calls; a reply without them ends the loop regardless.
+func StopOnText(re *regexp.Regexp) StopCondition {
+	return func(s Session, it Iteration) bool {
+		for _, m := range it.New(s) {
+			if am, ok := m.(AssistantMessage); ok && re.MatchString(am.Content) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// StopAfter is met once d has passed since the loop started.  It never
+// interrupts a model or tool call; use a context deadline for a hard limit.
+func StopAfter(d time.Duration) StopCondition {
+	return func(_ Session, it Iteration) bool { return time.Since(it.Started) >= d }
+}
+
+// StopAt is met once the wall clock passes deadline.  Like StopAfter, it is
+// only checked between iterations.
+func StopAt(deadline time.Time) StopCondition {
+	return func(Session, Iteration) bool { return !time.Now().Before(deadline) }
+}
+
+// StopWhen adapts a function over the whole session.
+func StopWhen(fn func(Session) bool) StopCondition {
+	return func(s Session, _ Iteration) bool { return fn(s) }
+}
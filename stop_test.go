@@ -0,0 +1,106 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStopConditions(t *testing.T) {
+	// Each iteration the model says something and calls a tool.
+	turn := func(text, tool string) []Message {
+		return []Message{AssistantMessage{text}, ToolCallMessage{ID: text, Name: tool, Input: json.RawMessage(`{}`)}}
+	}
+	echo := Tool{
+		Definition: ToolDefinition{Name: "echo", InputSchema: ToolInputSchema{Type: "object"}},
+		Handler:    func(context.Context, json.RawMessage) (string, error) { return "found it", nil },
+	}
+	responses := [][]Message{turn("one", "noop"), turn("two", "noop"), turn("three", "echo"), turn("four", "noop")}
+
+	tests := []struct {
+		name string
+		cond StopCondition
+		want int // iterations run
+	}{
+		{"tool call", StopOnToolCall("echo"), 3},
+		{"tool result", StopOnToolResult(func(r ToolResultMessage) bool { return strings.Contains(r.Output, "found") }), 3},
+		{"text", StopOnText(regexp.MustCompile(`^tw`)), 2},
+		{"session", StopWhen(func(s Session) bool { return len(s.Messages) >= 8 }), 2},
+		{"deadline", StopAt(time.Now()), 1},
+		{"duration", StopAfter(time.Hour), 5}, // never met; the script ends with a text reply
+	}
+	for _, tt := range tests {
+		model, offered := scriptedModel(responses...)
+		_, err := AgentLoop(context.Background(), model, []Tool{noopTool, echo}, InitSession("sys", "go"),
+			WithStopConditions(tt.cond))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if len(*offered) != tt.want {
+			t.Errorf("%s: ran %d iterations, want %d", tt.name, len(*offered), tt.want)
+		}
+	}
+
+	// Conditions only look at the current iteration, so a resumed session
+	// that already called echo does not stop straight away.
+	s := InitSession("sys", "go")
+	s.Add(turn("zero", "echo")...)
+	s.Add(ToolResultMessage{ID: "zero", Output: "found it"}, UserMessage{"again"})
+	model, offered := scriptedModel(responses...)
+	if _, err := AgentLoop(context.Background(), model, []Tool{noopTool, echo}, s,
+		WithStopConditions(StopOnToolCall("echo"))); err != nil || len(*offered) != 3 {
+		t.Errorf("resumed: %v after %d iterations", err, len(*offered))
+	}
+}
+
+// TestStopConditionsWithReflection checks that a final answer matching a
+// stop condition is still shown to the critic.
+func TestStopConditionsWithReflection(t *testing.T) {
+	model, offered := scriptedModel(
+		[]Message{AssistantMessage{"DONE: 41"}},
+		[]Message{AssistantMessage{"DONE: 42"}},
+	)
+	reviewed := 0
+	critic := func(_ context.Context, s Session) (Critique, error) {
+		reviewed++
+		answer, _ := s.FinalAnswer()
+		return Critique{Approved: answer == "DONE: 42", Feedback: "Off by one."}, nil
+	}
+	session, err := AgentLoop(context.Background(), model, nil, InitSession("sys", "go"),
+		WithStopConditions(StopOnText(regexp.MustCompile(`^DONE`))), WithReflection(critic, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer, _ := session.FinalAnswer(); answer != "DONE: 42" || reviewed != 2 || len(*offered) != 2 {
+		t.Errorf("answer %q after %d reviews and %d iterations", answer, reviewed, len(*offered))
+	}
+}
+
+// TestStopConditionsWithAnswer checks that a met condition in a
+// structured-answer loop forces the answer instead of ending without one.
+func TestStopConditionsWithAnswer(t *testing.T) {
+	var choices []ToolChoice
+	script, _ := scriptedModel(
+		[]Message{ToolCallMessage{ID: "n1", Name: "noop", Input: json.RawMessage(`{}`)}},
+		[]Message{submit("a1", `{"grade":"high","score":9}`)},
+	)
+	model := func(ctx context.Context, defs []ToolDefinition, s Session) ([]Message, Usage, error) {
+		tc, _ := ToolChoiceFromContext(ctx)
+		choices = append(choices, tc)
+		return script(ctx, defs, s)
+	}
+	got, session, err := AgentLoopAnswer[gradeAnswer](context.Background(), model, []Tool{noopTool},
+		InitSession("sys", "Grade this."), WithStopConditions(StopOnToolCall("noop")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Grade != "high" || len(choices) != 2 || choices[1] != ToolChoiceTool(SubmitAnswerTool) {
+		t.Errorf("got %+v with tool choices %+v", got, choices)
+	}
+	if reminders := MessagesOf[UserMessage](session); len(reminders) != 2 || reminders[1].Content != answerReminder {
+		t.Errorf("user messages: %+v", reminders)
+	}
+}
@@ -0,0 +1,110 @@
+package agentloop
+
+import (
+	"context"
+
+	anthropic "github.com/anthropics/anthropic-sdk-go"
+)
+
+// ToolChoice controls whether and which tools the model may call on a
+// request.  The zero value leaves the choice to the provider (auto).
+type ToolChoice struct {
+	Type string // "auto", "any", "tool" or "none"; empty for the default
+	Name string // tool to call when Type is "tool"
+
+	// DisableParallelToolUse limits the model to at most one tool call per
+	// response ("auto") or exactly one ("any" and "tool").  It has no effect
+	// with "none".
+	DisableParallelToolUse bool
+}
+
+var (
+	// ToolChoiceAuto lets the model decide whether to call tools.
+	ToolChoiceAuto = ToolChoice{Type: "auto"}
+	// ToolChoiceAny requires the model to call at least one tool.
+	ToolChoiceAny = ToolChoice{Type: "any"}
+	// ToolChoiceNone prevents the model from calling tools.
+	ToolChoiceNone = ToolChoice{Type: "none"}
+)
+
+// ToolChoiceTool requires the model to call the named tool.
+func ToolChoiceTool(name string) ToolChoice {
+	return ToolChoice{Type: "tool", Name: name}
+}
+
+// Sequential returns a copy of c with parallel tool use disabled.
+func (c ToolChoice) Sequential() ToolChoice {
+	c.DisableParallelToolUse = true
+	return c
+}
+
+// IsZero reports whether c is the default choice.
+func (c ToolChoice) IsZero() bool { return c == ToolChoice{} }
+
+// ToolChoiceFunc picks the tool choice for one AgentLoop iteration, given
+// the zero-based iteration number and the session about to be sent.
+// Returning the zero ToolChoice keeps the model's default.
+type ToolChoiceFunc func(iteration int, session Session) ToolChoice
+
+// ForceToolFirst returns a ToolChoiceFunc that requires the named tool on
+// the first iteration and leaves later iterations to the model.
+func ForceToolFirst(name string) ToolChoiceFunc {
+	return func(iteration int, _ Session) ToolChoice {
+		if iteration == 0 {
+			return ToolChoiceTool(name)
+		}
+		return ToolChoice{}
+	}
+}
+
+type toolChoiceKey struct{}
+
+// ContextWithToolChoice returns a context carrying a tool choice for the
+// next model invocation.  AgentLoop uses it to pass the result of a
+// ToolChoiceFunc to the InvokeModelFunc; a choice in the context takes
+// precedence over a WithToolChoice option.
+func ContextWithToolChoice(ctx context.Context, c ToolChoice) context.Context {
+	return context.WithValue(ctx, toolChoiceKey{}, c)
+}
+
+// ToolChoiceFromContext returns the tool choice stored by
+// ContextWithToolChoice, if any.  Custom InvokeModelFunc implementations
+// should honour it.
+func ToolChoiceFromContext(ctx context.Context) (ToolChoice, bool) {
+	c, ok := ctx.Value(toolChoiceKey{}).(ToolChoice)
+	return c, ok && !c.IsZero()
+}
+
+// toolChoiceParam converts a ToolChoice to its API form.  ok is false for
+// the zero value, which should be omitted from the request.
+func toolChoiceParam(c ToolChoice) (p anthropic.ToolChoiceUnionParam, ok bool) {
+	disable := anthropic.Bool(c.DisableParallelToolUse)
+	switch c.Type {
+	case "auto":
+		p.OfAuto = &anthropic.ToolChoiceAutoParam{}
+		if c.DisableParallelToolUse {
+			p.OfAuto.DisableParallelToolUse = disable
+		}
+	case "any":
+		p.OfAny = &anthropic.ToolChoiceAnyParam{}
+		if c.DisableParallelToolUse {
+			p.OfAny.DisableParallelToolUse = disable
+		}
+	case "tool":
+		p = anthropic.ToolChoiceParamOfTool(c.Name)
+		if c.DisableParallelToolUse {
+			p.OfTool.DisableParallelToolUse = disable
+		}
+	case "none":
+		none := anthropic.NewToolChoiceNoneParam()
+		p.OfNone = &none
+	case "":
+		if !c.DisableParallelToolUse {
+			return p, false
+		}
+		p.OfAuto = &anthropic.ToolChoiceAutoParam{DisableParallelToolUse: disable}
+	default:
+		return p, false
+	}
+	return p, true
+}
@@ -0,0 +1,71 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestToolChoiceParam(t *testing.T) {
+	tests := []struct {
+		choice ToolChoice
+		want   string // empty when the parameter should be omitted
+	}{
+		{ToolChoice{}, ""},
+		{ToolChoiceAuto, `{"type":"auto"}`},
+		{ToolChoiceAny.Sequential(), `{"disable_parallel_tool_use":true,"type":"any"}`},
+		{ToolChoiceTool("plan"), `{"name":"plan","type":"tool"}`},
+		{ToolChoiceNone, `{"type":"none"}`},
+		{ToolChoice{}.Sequential(), `{"disable_parallel_tool_use":true,"type":"auto"}`},
+	}
+	for _, tt := range tests {
+		p, ok := toolChoiceParam(tt.choice)
+		if !ok {
+			if tt.want != "" {
+				t.Errorf("%+v: omitted, want %s", tt.choice, tt.want)
+			}
+			continue
+		}
+		data, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%+v: got %s, want %s", tt.choice, data, tt.want)
+		}
+	}
+}
+
+// TestToolChoicePolicy checks that AgentLoop passes each iteration's choice
+// to the model through the context.
+func TestToolChoicePolicy(t *testing.T) {
+	var seen []ToolChoice
+	invoker := func(ctx context.Context, _ []ToolDefinition, s Session) ([]Message, Usage, error) {
+		tc, _ := ToolChoiceFromContext(ctx)
+		seen = append(seen, tc)
+		if len(seen) < 3 {
+			return []Message{ToolCallMessage{ID: "c" + string(rune('0'+len(seen))), Name: "noop", Input: json.RawMessage(`{}`)}}, Usage{}, nil
+		}
+		return []Message{AssistantMessage{"done"}}, Usage{}, nil
+	}
+
+	policy := func(i int, s Session) ToolChoice {
+		if i == 2 {
+			return ToolChoiceNone
+		}
+		return ForceToolFirst("noop")(i, s)
+	}
+	if _, err := AgentLoop(context.Background(), invoker, []Tool{noopTool}, InitSession("sys", "u"),
+		WithToolChoicePolicy(policy)); err != nil {
+		t.Fatal(err)
+	}
+	want := []ToolChoice{ToolChoiceTool("noop"), {}, ToolChoiceNone}
+	if len(seen) != len(want) {
+		t.Fatalf("got %d calls, want %d", len(seen), len(want))
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Errorf("iteration %d: got %+v, want %+v", i, seen[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package agentloop
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ValidationIssue describes a single problem that would cause the Anthropic
+// API to reject a session.
+type ValidationIssue struct {
+	Index   int // position in Session.Messages; -1 for session-wide issues
+	Problem string
+}
+
+func (i ValidationIssue) String() string {
+	if i.Index < 0 {
+		return i.Problem
+	}
+	return fmt.Sprintf("message %d: %s", i.Index, i.Problem)
+}
+
+// ValidationError is returned by Session.Validate and lists every issue found.
+type ValidationError struct {
+	Issues []ValidationIssue
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		parts[i] = issue.String()
+	}
+	return "invalid session: " + strings.Join(parts, "; ")
+}
+
+// cancelledOutput is the result synthesized by Repair for tool calls that
+// never received a result (typically because the run was cancelled).
+const cancelledOutput = "Error: tool call cancelled before a result was produced"
+
+// resumedPrompt is inserted by Repair ahead of a leading assistant turn.
+const resumedPrompt = "(conversation resumed)"
+
+// turn is a run of consecutive same-role messages, mirroring the grouping
+// performed by buildParams.  idx holds positions in Session.Messages.
+type turn struct {
+	role string
+	idx  []int
+}
+
+// messageRole returns the API role a message is sent under, or "" for
+// messages that buildParams does not place in a turn.
+func messageRole(msg Message) string {
+	switch msg.(type) {
+	case UserMessage, ToolResultMessage:
+		return "user"
+	case AssistantMessage, ToolCallMessage:
+		return "assistant"
+	default:
+		return ""
+	}
+}
+
+// groupTurns splits a session into role turns the same way buildParams does.
+func groupTurns(s Session) []turn {
+	var turns []turn
+	for i, msg := range s.Messages {
+		role := messageRole(msg)
+		if role == "" {
+			continue
+		}
+		if len(turns) > 0 && turns[len(turns)-1].role == role {
+			turns[len(turns)-1].idx = append(turns[len(turns)-1].idx, i)
+		} else {
+			turns = append(turns, turn{role: role, idx: []int{i}})
+		}
+	}
+	return turns
+}
+
+// isJSONObject reports whether raw is a syntactically valid JSON object.
+func isJSONObject(raw json.RawMessage) bool {
+	var v map[string]json.RawMessage
+	return json.Unmarshal(raw, &v) == nil && v != nil
+}
+
+// Validate checks that the session can be sent to the model without being
+// rejected.  It returns nil for a valid session, or a *ValidationError
+// listing every problem in message order.
+//
+// Checked conditions:
+//   - the conversation contains at least one user or assistant message
+//   - the first turn is a user turn
+//   - user and assistant messages have non-empty content
+//   - tool call IDs are non-empty and unique, and inputs are JSON objects
+//   - every tool call has exactly one result in the turn that follows it
+//   - every tool result answers a tool call from the preceding turn
+func (s Session) Validate() error {
+	var issues []ValidationIssue
+	add := func(i int, format string, args ...any) {
+		issues = append(issues, ValidationIssue{Index: i, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	turns := groupTurns(s)
+	if len(turns) == 0 {
+		add(-1, "session has no user or assistant messages")
+		return &ValidationError{issues}
+	}
+	if turns[0].role == "assistant" {
+		add(turns[0].idx[0], "conversation starts with an assistant turn")
+	}
+
+	// Locate every call so results can be checked against it.
+	callTurn := make(map[string]int)  // call ID → turn index
+	callIndex := make(map[string]int) // call ID → message index
+	for t, tr := range turns {
+		for _, i := range tr.idx {
+			if tc, ok := s.Messages[i].(ToolCallMessage); ok {
+				if _, dup := callIndex[tc.ID]; !dup && tc.ID != "" {
+					callTurn[tc.ID] = t
+					callIndex[tc.ID] = i
+				}
+			}
+		}
+	}
+
+	answered := make(map[string]bool)
+	for t, tr := range turns {
+		for _, i := range tr.idx {
+			switch m := s.Messages[i].(type) {
+			case UserMessage:
+				if m.Content == "" {
+					add(i, "user message has empty content")
+				}
+			case AssistantMessage:
+				if m.Content == "" {
+					add(i, "assistant message has empty content")
+				}
+			case ToolCallMessage:
+				switch {
+				case m.ID == "":
+					add(i, "tool call %q has an empty ID", m.Name)
+				case callIndex[m.ID] != i:
+					add(i, "duplicate tool call ID %q (first used at message %d)", m.ID, callIndex[m.ID])
+				}
+				if !isJSONObject(m.Input) {
+					add(i, "tool call %q input is not a JSON object", m.ID)
+				}
+			case ToolResultMessage:
+				ct, ok := callTurn[m.ID]
+				switch {
+				case m.ID == "":
+					add(i, "tool result has an empty ID")
+				case !ok:
+					add(i, "tool result %q has no matching tool call", m.ID)
+				case answered[m.ID]:
+					add(i, "duplicate tool result for call %q", m.ID)
+				case ct > t:
+					add(i, "tool result %q precedes its tool call at message %d", m.ID, callIndex[m.ID])
+				case ct != t-1:
+					add(i, "tool result %q is not in the turn immediately after its tool call at message %d", m.ID, callIndex[m.ID])
+				}
+				answered[m.ID] = true
+			}
+		}
+	}
+
+	// Every call needs a result in the following turn.
+	for t, tr := range turns {
+		for _, i := range tr.idx {
+			tc, ok := s.Messages[i].(ToolCallMessage)
+			if !ok || tc.ID == "" || callIndex[tc.ID] != i {
+				continue
+			}
+			if t+1 >= len(turns) || !turnHasResult(s, turns[t+1], tc.ID) {
+				add(i, "tool call %q has no result in the following turn", tc.ID)
+			}
+		}
+	}
+
+	if len(issues) == 0 {
+		return nil
+	}
+	sort.SliceStable(issues, func(a, b int) bool { return issues[a].Index < issues[b].Index })
+	return &ValidationError{issues}
+}
+
+// turnHasResult reports whether tr contains a ToolResultMessage for id.
+func turnHasResult(s Session, tr turn, id string) bool {
+	for _, i := range tr.idx {
+		if r, ok := s.Messages[i].(ToolResultMessage); ok && r.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Repair returns a copy of s with the common causes of Validate failures
+// fixed.  The input session is not modified.
+//
+// Repairs applied:
+//   - empty user and assistant messages are dropped
+//   - tool calls with an empty or duplicate ID are dropped
+//   - tool call inputs that are not JSON objects are replaced with {}
+//   - tool results are moved to directly follow the turn containing their
+//     call, merging them into that position; duplicates and results with no
+//     matching call are dropped
+//   - tool calls with no result get a synthesized "cancelled" result
+//   - a leading assistant turn is preceded by a placeholder user message
+func Repair(s Session) Session {
+	// First pass: find the first result for each call ID.
+	// Messages keep their times; synthesized ones have none.
+	results := make(map[string]ToolResultMessage)
+	resultTimes := make(map[string]time.Time)
+	for i, msg := range s.Messages {
+		if r, ok := msg.(ToolResultMessage); ok {
+			if _, seen := results[r.ID]; !seen {
+				results[r.ID] = r
+				resultTimes[r.ID] = s.Time(i)
+			}
+		}
+	}
+
+	// Second pass: rebuild the history.  Results are never copied where
+	// they stand; instead they are emitted when the assistant turn holding
+	// their call ends, which handles misplaced, early and missing results
+	// uniformly.
+	out := Session{State: s.State}
+	emitted := make(map[string]bool)
+	var pending []string
+	flush := func() {
+		for _, id := range pending {
+			r, ok := results[id]
+			if !ok {
+				r = ToolResultMessage{ID: id, Output: cancelledOutput}
+			}
+			out.addAt(resultTimes[id], r)
+		}
+		pending = nil
+	}
+
+	for i, msg := range s.Messages {
+		at := s.Time(i)
+		switch m := msg.(type) {
+		case UserMessage:
+			if m.Content == "" {
+				continue
+			}
+			flush()
+			out.addAt(at, m)
+		case ToolResultMessage:
+			flush()
+		case AssistantMessage:
+			if m.Content == "" {
+				continue
+			}
+			out.addAt(at, m)
+		case ToolCallMessage:
+			if m.ID == "" || emitted[m.ID] {
+				continue
+			}
+			emitted[m.ID] = true
+			if !isJSONObject(m.Input) {
+				m.Input = json.RawMessage(`{}`)
+			}
+			out.addAt(at, m)
+			pending = append(pending, m.ID)
+		default:
+			out.addAt(at, m)
+		}
+	}
+	flush()
+
+	turns := groupTurns(out)
+	if len(turns) > 0 && turns[0].role == "assistant" {
+		at := turns[0].idx[0]
+		msgs := make([]Message, 0, len(out.Messages)+1)
+		msgs = append(msgs, out.Messages[:at]...)
+		msgs = append(msgs, UserMessage{resumedPrompt})
+		msgs = append(msgs, out.Messages[at:]...)
+		if len(out.Times) > 0 {
+			times := make([]time.Time, 0, len(msgs))
+			times = append(times, out.Times[:at]...)
+			times = append(times, time.Time{})
+			times = append(times, out.Times[at:]...)
+			out.Times = times
+		}
+		out.Messages = msgs
+	}
+	return out
+}
@@ -0,0 +1,161 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestValidateValidSession confirms that a well-formed tool-using session
+// passes validation.
+func TestValidateValidSession(t *testing.T) {
+	s := InitSession("sys", "user")
+	s.Add(
+		ThinkingMessage{"hmm"},
+		AssistantMessage{"Let me check."},
+		ToolCallMessage{ID: "c1", Name: "noop", Input: json.RawMessage(`{}`)},
+		ToolCallMessage{ID: "c2", Name: "noop", Input: json.RawMessage(`{"a":1}`)},
+		ToolResultMessage{ID: "c1", Output: "ok"},
+		ToolResultMessage{ID: "c2", Output: "ok"},
+		AssistantMessage{"done"},
+	)
+	if err := s.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// TestValidateDiagnostics checks that each kind of broken history produces a
+// diagnostic pointing at the offending message.
+func TestValidateDiagnostics(t *testing.T) {
+	call := func(id string) ToolCallMessage {
+		return ToolCallMessage{ID: id, Name: "noop", Input: json.RawMessage(`{}`)}
+	}
+	tests := []struct {
+		name      string
+		msgs      []Message
+		wantIndex int
+		wantText  string
+	}{
+		{"empty", []Message{SystemMessage{"sys"}}, -1, "no user or assistant messages"},
+		{"leading assistant", []Message{AssistantMessage{"hi"}, UserMessage{"u"}}, 0, "starts with an assistant turn"},
+		{"empty user", []Message{UserMessage{""}}, 0, "empty content"},
+		{"orphan call", []Message{UserMessage{"u"}, call("c1")}, 1, `"c1" has no result`},
+		{"orphan result", []Message{UserMessage{"u"}, ToolResultMessage{ID: "x", Output: "o"}}, 1, "no matching tool call"},
+		{"result before call", []Message{UserMessage{"u"}, ToolResultMessage{ID: "c1"}, AssistantMessage{"a"}, call("c1")}, 1, "precedes its tool call"},
+		{"duplicate call", []Message{UserMessage{"u"}, call("c1"), call("c1"), ToolResultMessage{ID: "c1"}}, 2, "duplicate tool call ID"},
+		{"duplicate result", []Message{UserMessage{"u"}, call("c1"), ToolResultMessage{ID: "c1"}, ToolResultMessage{ID: "c1"}}, 3, "duplicate tool result"},
+		{"late result", []Message{UserMessage{"u"}, call("c1"), UserMessage{"u2"}, AssistantMessage{"a"}, ToolResultMessage{ID: "c1"}}, 4, "not in the turn immediately after"},
+		{"bad input", []Message{UserMessage{"u"}, ToolCallMessage{ID: "c1", Name: "noop", Input: json.RawMessage(`"trunc`)}, ToolResultMessage{ID: "c1"}}, 1, "not a JSON object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Session{Messages: tt.msgs}.Validate()
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("expected *ValidationError, got %v", err)
+			}
+			for _, issue := range verr.Issues {
+				if issue.Index == tt.wantIndex && strings.Contains(issue.Problem, tt.wantText) {
+					return
+				}
+			}
+			t.Errorf("no issue at index %d containing %q; got %v", tt.wantIndex, tt.wantText, verr)
+		})
+	}
+}
+
+// TestRepair builds a session exhibiting every repairable problem and checks
+// that the repaired result validates and has the expected shape.
+func TestRepair(t *testing.T) {
+	s := Session{}
+	s.Add(
+		SystemMessage{"sys"},
+		AssistantMessage{"leftover from a previous run"},
+		UserMessage{"u"},
+		ToolResultMessage{ID: "c1", Output: "early"}, // before its call
+		ToolCallMessage{ID: "c1", Name: "noop", Input: json.RawMessage(`{}`)},
+		ToolCallMessage{ID: "c1", Name: "noop", Input: json.RawMessage(`{}`)}, // duplicate
+		ToolCallMessage{ID: "c2", Name: "noop", Input: json.RawMessage(`{"x":`)},
+		UserMessage{""},
+		ToolResultMessage{ID: "ghost", Output: "orphan"},
+		ToolCallMessage{ID: "c3", Name: "noop", Input: json.RawMessage(`{}`)}, // cancelled
+	)
+	original := len(s.Messages)
+	start := time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC)
+	for i := range s.Times {
+		s.Times[i] = start.Add(time.Duration(i) * time.Second)
+	}
+
+	repaired := Repair(s)
+	if err := repaired.Validate(); err != nil {
+		t.Fatalf("repaired session still invalid: %v", err)
+	}
+	if len(s.Messages) != original {
+		t.Error("Repair modified its input")
+	}
+
+	want := []string{
+		`{"role":"system","content":"sys"}`,
+		`{"role":"user","content":"(conversation resumed)"}`,
+		`{"role":"assistant","content":"leftover from a previous run"}`,
+		`{"role":"user","content":"u"}`,
+		`{"type":"tool_call","id":"c1","name":"noop","input":{}}`,
+		`{"type":"tool_call","id":"c2","name":"noop","input":{}}`,
+		`{"type":"tool_result","id":"c1","output":"early"}`,
+		`{"type":"tool_result","id":"c2","output":"` + cancelledOutput + `"}`,
+		`{"type":"tool_call","id":"c3","name":"noop","input":{}}`,
+		`{"type":"tool_result","id":"c3","output":"` + cancelledOutput + `"}`,
+	}
+	if len(repaired.Messages) != len(want) {
+		data, _ := json.Marshal(repaired)
+		t.Fatalf("got %d messages, want %d: %s", len(repaired.Messages), len(want), data)
+	}
+	for i, msg := range repaired.Messages {
+		got, _ := json.Marshal(msg)
+		if string(got) != want[i] {
+			t.Errorf("[%d] got %s, want %s", i, got, want[i])
+		}
+	}
+
+	// Moved messages keep their times; synthesized ones have none.
+	if !repaired.Time(6).Equal(s.Time(3)) || !repaired.Time(2).Equal(s.Time(1)) ||
+		!repaired.Time(1).IsZero() || !repaired.Time(7).IsZero() {
+		t.Errorf("times: %v", repaired.Times)
+	}
+}
+
+// TestAgentLoopValidation confirms that WithValidation rejects a broken
+// session before invoking the model, and WithRepair fixes it so the loop runs.
+func TestAgentLoopValidation(t *testing.T) {
+	broken := InitSession("sys", "user")
+	broken.Add(ToolCallMessage{ID: "c1", Name: "noop", Input: json.RawMessage(`{}`)})
+
+	called := false
+	invoker := func(context.Context, []ToolDefinition, Session) ([]Message, Usage, error) {
+		called = true
+		return []Message{AssistantMessage{"done"}}, Usage{}, nil
+	}
+
+	_, err := AgentLoop(context.Background(), invoker, nil, broken, WithValidation())
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected *ValidationError, got %v", err)
+	}
+	if called {
+		t.Error("model invoked despite invalid session")
+	}
+
+	out, err := AgentLoop(context.Background(), invoker, nil, broken, WithRepair(), WithValidation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Error("model not invoked after repair")
+	}
+	if err := out.Validate(); err != nil {
+		t.Errorf("final session invalid: %v", err)
+	}
+}